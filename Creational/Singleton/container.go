@@ -0,0 +1,272 @@
+package Singleton
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotRegistered    = errors.New("no provider registered")
+	ErrDependencyCycle  = errors.New("dependency cycle")
+	ErrContainerClosed  = errors.New("container is closed")
+	ErrProviderMismatch = errors.New("provider returned the wrong type")
+)
+
+// CycleError lists the types of a dependency cycle, the first and the last are the same.
+type CycleError struct {
+	Chain []reflect.Type
+}
+
+func (e *CycleError) Error() string {
+	names := make([]string, len(e.Chain))
+	for i, t := range e.Chain {
+		names[i] = t.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrDependencyCycle, strings.Join(names, " -> "))
+}
+
+func (e *CycleError) Is(target error) bool {
+	return target == ErrDependencyCycle
+}
+
+type lifetime int
+
+const (
+	// singletonLifetime: built once per container, on first resolve.
+	singletonLifetime lifetime = iota
+	// transientLifetime: built on every resolve, closing it is up to the caller.
+	transientLifetime
+	// scopedLifetime: built once per Scope and closed with it.
+	scopedLifetime
+)
+
+type provider struct {
+	lifetime lifetime
+	build    func(*Scope) (any, error)
+}
+
+// container.go: a dependency injection container, the composition root that
+// replaces one global variable per singleton
+//
+// Providers are registered per type with provideSingleton, provideTransient or
+// provideScoped and looked up by type with resolve. Singletons and scoped
+// instances live in a Registry, so they get its once-semantics, are closed if
+// they implement io.Closer, and shut down newest first. A cycle is reported by
+// the first resolve that walks it, also when two goroutines start at its
+// opposite ends and each builds the instance the other one waits for.
+type Container struct {
+	mu         sync.RWMutex
+	providers  map[reflect.Type]provider
+	singletons *Registry[reflect.Type, any]
+	root       *Scope
+	closed     bool
+
+	// graph guards who builds which instance and who waits for which
+	graph    sync.Mutex
+	builders map[buildKey]*resolution
+	waiting  map[*resolution]buildKey
+}
+
+func newContainer() *Container {
+	c := &Container{
+		providers:  make(map[reflect.Type]provider),
+		singletons: newRegistry[reflect.Type, any](nil),
+		builders:   make(map[buildKey]*resolution),
+		waiting:    make(map[*resolution]buildKey),
+	}
+	c.root = c.newScope()
+	return c
+}
+
+// Scope resolves within one unit of work, such as a request. The scope a
+// provider gets also carries the chain of types being resolved, which is how
+// cycles are found.
+type Scope struct {
+	container  *Container
+	instances  *Registry[reflect.Type, any]
+	chain      []reflect.Type
+	resolution *resolution
+}
+
+// resolution is one call of resolve from outside a provider, with everything it builds.
+type resolution struct {
+	root reflect.Type
+}
+
+// buildKey is an instance of a registry, the singletons or a scope's.
+type buildKey struct {
+	instances *Registry[reflect.Type, any]
+	t         reflect.Type
+}
+
+func (c *Container) newScope() *Scope {
+	return &Scope{
+		container: c,
+		instances: newRegistry[reflect.Type, any](nil),
+	}
+}
+
+// Close closes the scoped instances, newest first.
+func (s *Scope) Close() error {
+	return s.instances.Close()
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func provideSingleton[T any](c *Container, build func(*Scope) (T, error)) {
+	provide(c, singletonLifetime, build)
+}
+
+func provideTransient[T any](c *Container, build func(*Scope) (T, error)) {
+	provide(c, transientLifetime, build)
+}
+
+func provideScoped[T any](c *Container, build func(*Scope) (T, error)) {
+	provide(c, scopedLifetime, build)
+}
+
+// provide replaces any earlier provider of T, a singleton built by it is disposed.
+func provide[T any](c *Container, lifetime lifetime, build func(*Scope) (T, error)) {
+	t := typeOf[T]()
+	c.mu.Lock()
+	c.providers[t] = provider{
+		lifetime: lifetime,
+		build: func(s *Scope) (any, error) {
+			return build(s)
+		},
+	}
+	c.mu.Unlock()
+	c.singletons.dispose(t)
+}
+
+// resolve returns the T of s's container, built by the provider registered for T.
+func resolve[T any](s *Scope) (T, error) {
+	var zero T
+	v, err := s.resolve(typeOf[T]())
+	if err != nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %T for %s", ErrProviderMismatch, v, typeOf[T]())
+	}
+	return t, nil
+}
+
+func (s *Scope) resolve(t reflect.Type) (any, error) {
+	for i, seen := range s.chain {
+		if seen == t {
+			chain := append(append([]reflect.Type(nil), s.chain[i:]...), t)
+			return nil, &CycleError{Chain: chain}
+		}
+	}
+	c := s.container
+	c.mu.RLock()
+	p, ok := c.providers[t]
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return nil, ErrContainerClosed
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNotRegistered, t)
+	}
+
+	next := &Scope{
+		container:  c,
+		instances:  s.instances,
+		chain:      append(s.chain[:len(s.chain):len(s.chain)], t),
+		resolution: s.resolution,
+	}
+	if next.resolution == nil {
+		next.resolution = &resolution{root: t}
+	}
+	switch p.lifetime {
+	case singletonLifetime:
+		// a singleton must not hold on to anything scoped, so it is built in the root scope
+		next.instances = c.root.instances
+		return c.getOrBuild(c.singletons, next, p)
+	case scopedLifetime:
+		return c.getOrBuild(s.instances, next, p)
+	}
+	return p.build(next)
+}
+
+// getOrBuild gets the instance of the last type in next's chain from
+// instances, building it with p if it isn't there yet. Before it waits for
+// another resolution building the instance, it checks that resolution
+// doesn't wait, directly or further down, for something this one builds.
+func (c *Container) getOrBuild(instances *Registry[reflect.Type, any], next *Scope, p provider) (any, error) {
+	t := next.chain[len(next.chain)-1]
+	key := buildKey{instances, t}
+	if !instances.loaded(t) {
+		if err := c.wait(next, key); err != nil {
+			return nil, err
+		}
+		defer c.stopWaiting(next.resolution)
+	}
+	return instances.getOrCreate(t, func() (any, error) {
+		c.graph.Lock()
+		delete(c.waiting, next.resolution)
+		c.builders[key] = next.resolution
+		c.graph.Unlock()
+		defer func() {
+			c.graph.Lock()
+			delete(c.builders, key)
+			c.graph.Unlock()
+		}()
+		return p.build(next)
+	})
+}
+
+// wait records that next's resolution waits for key, unless that closes a
+// cycle through other resolutions. Then the cycle is returned.
+func (c *Container) wait(next *Scope, key buildKey) error {
+	res := next.resolution
+	c.graph.Lock()
+	defer c.graph.Unlock()
+	types := []reflect.Type{key.t}
+	owner := c.builders[key]
+	for owner != nil && owner != res {
+		k, ok := c.waiting[owner]
+		if !ok {
+			break
+		}
+		types = append(types, k.t)
+		owner = c.builders[k]
+	}
+	if owner == res && len(types) > 1 {
+		// the last type is one this resolution builds, its chain leads from it to key
+		chain := next.chain[:len(next.chain)-1]
+		for i, seen := range chain {
+			if seen == types[len(types)-1] {
+				types = append(append(types, chain[i+1:]...), key.t)
+				return &CycleError{Chain: types}
+			}
+		}
+		return &CycleError{Chain: append(types, key.t)}
+	}
+	c.waiting[res] = key
+	return nil
+}
+
+func (c *Container) stopWaiting(res *resolution) {
+	c.graph.Lock()
+	delete(c.waiting, res)
+	c.graph.Unlock()
+}
+
+// Close shuts down the root scope and then the singletons, each newest first.
+// Resolving anything afterwards fails with ErrContainerClosed.
+func (c *Container) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	err := c.root.Close()
+	return errors.Join(err, c.singletons.Close())
+}
@@ -0,0 +1,53 @@
+package Singleton
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lazy.go: a value created on first use, the double-checked locking of getInstance in a generic type
+//
+// Unlike sync.Once a failed init is not remembered: the caller gets the error
+// and the next get runs init again.
+type Lazy[T any] struct {
+	init func() (T, error)
+
+	mu    sync.Mutex
+	value atomic.Pointer[T]
+}
+
+func newLazy[T any](init func() (T, error)) *Lazy[T] {
+	return &Lazy[T]{
+		init: init,
+	}
+}
+
+func (l *Lazy[T]) get() (T, error) {
+	if v := l.value.Load(); v != nil {
+		return *v, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v := l.value.Load(); v != nil {
+		return *v, nil
+	}
+	v, err := l.init()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	l.value.Store(&v)
+	return v, nil
+}
+
+func (l *Lazy[T]) loaded() bool {
+	return l.value.Load() != nil
+}
+
+// reset forgets the value so the next get runs init again, for tests.
+// Callers that already got the old value keep it.
+func (l *Lazy[T]) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.value.Store(nil)
+}
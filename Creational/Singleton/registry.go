@@ -0,0 +1,143 @@
+package Singleton
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ErrRegistryClosed = errors.New("registry is closed")
+
+// registry.go: a multiton, one lazily created instance per key
+//
+// Each key has its own Lazy, so instances for different keys are built in
+// parallel while concurrent gets of one key still build it once. Instances
+// that implement io.Closer are closed by dispose and by Close.
+type Registry[K comparable, V any] struct {
+	create func(K) (V, error)
+
+	mu      sync.Mutex
+	entries map[K]*Lazy[V]
+	order   []K
+	closed  bool
+}
+
+func newRegistry[K comparable, V any](create func(K) (V, error)) *Registry[K, V] {
+	return &Registry[K, V]{
+		create:  create,
+		entries: make(map[K]*Lazy[V]),
+	}
+}
+
+func (r *Registry[K, V]) get(key K) (V, error) {
+	return r.getOrCreate(key, func() (V, error) {
+		return r.create(key)
+	})
+}
+
+// getOrCreate is get with create standing in for the registry's own
+// constructor, for callers that build each key differently.
+func (r *Registry[K, V]) getOrCreate(key K, create func() (V, error)) (V, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		var zero V
+		return zero, ErrRegistryClosed
+	}
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = newLazy(func() (V, error) {
+			return r.build(key, entry, create)
+		})
+		r.entries[key] = entry
+	}
+	r.mu.Unlock()
+	return entry.get()
+}
+
+// build runs create for key and records the instance, unless key was disposed
+// or the registry closed while create ran. Then the new instance is closed again.
+func (r *Registry[K, V]) build(key K, entry *Lazy[V], create func() (V, error)) (V, error) {
+	v, err := create()
+	if err != nil {
+		return v, err
+	}
+	r.mu.Lock()
+	current := !r.closed && r.entries[key] == entry
+	if current {
+		r.order = append(r.order, key)
+	}
+	r.mu.Unlock()
+	if !current {
+		closeInstance(v)
+		var zero V
+		return zero, fmt.Errorf("%w: %v was disposed while being created", ErrRegistryClosed, key)
+	}
+	return v, nil
+}
+
+func (r *Registry[K, V]) loaded(key K) bool {
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	r.mu.Unlock()
+	return ok && entry.loaded()
+}
+
+func (r *Registry[K, V]) keys() []K {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]K(nil), r.order...)
+}
+
+// dispose removes key's instance and closes it. The next get creates a new one.
+func (r *Registry[K, V]) dispose(key K) error {
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	delete(r.entries, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if v := entry.value.Load(); v != nil {
+		return closeInstance(*v)
+	}
+	return nil
+}
+
+// Close closes every instance, newest first, so an instance created while
+// using an older one is gone before the older one. Later gets fail.
+func (r *Registry[K, V]) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	order, entries := r.order, r.entries
+	r.order, r.entries = nil, make(map[K]*Lazy[V])
+	r.mu.Unlock()
+
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if v := entries[order[i]].value.Load(); v != nil {
+			if err := closeInstance(*v); err != nil {
+				errs = append(errs, fmt.Errorf("close %v: %w", order[i], err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeInstance(v any) error {
+	if closer, ok := v.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
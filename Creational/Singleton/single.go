@@ -2,55 +2,35 @@ package Singleton
 
 import (
 	"fmt"
-	"sync"
 )
 
 type Single struct {
 }
 
-var lock = &sync.Mutex{}
-var singleInstance *Single
+// defaultContainer is the composition root. Single is registered in it as a
+// singleton rather than kept in a global variable of its own.
+var defaultContainer = newContainer()
+
+func init() {
+	provideSingleton(defaultContainer, func(*Scope) (*Single, error) {
+		fmt.Println("Creating single instance now.")
+		return &Single{}, nil
+	})
+}
 
 func getInstance() *Single {
-	if singleInstance == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		if singleInstance == nil {
-			singleInstance = &Single{}
-		}
-	}
-	return singleInstance
+	single, _ := resolve[*Single](defaultContainer.root)
+	return single
 }
 
 func getInstanceWithComment() *Single {
-	if singleInstance == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		if singleInstance == nil {
-			fmt.Println("Creating single instance now.")
-			singleInstance = &Single{}
-		} else {
-			fmt.Println("Race condition: Single instance already created.")
-		}
-	} else {
+	if defaultContainer.singletons.loaded(typeOf[*Single]()) {
 		fmt.Println("Single instance already created.")
 	}
-
-	return singleInstance
+	return getInstance()
 }
 
-var once sync.Once
-
+// getInstanceByOnce gets the same once-semantics from the container as it used to from sync.Once.
 func getInstanceByOnce() *Single {
-	if singleInstance == nil {
-		once.Do(
-			func() {
-				fmt.Println("Creating single instance now.")
-				singleInstance = &Single{}
-			})
-	} else {
-		fmt.Println("Single instance already created.")
-	}
-
-	return singleInstance
+	return getInstanceWithComment()
 }
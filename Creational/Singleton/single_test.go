@@ -1,8 +1,13 @@
 package Singleton
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSingle(t *testing.T) {
@@ -30,3 +35,305 @@ func TestSingleWithComment(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestLazy(t *testing.T) {
+	calls := 0
+	lazy := newLazy(func() (int, error) {
+		calls++
+		if calls <= 2 {
+			return 0, errors.New("not ready")
+		}
+		return calls, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := lazy.get(); err == nil || lazy.loaded() {
+			t.Fatal("failed init was remembered")
+		}
+	}
+
+	cnt := 30
+	results := make([]int, cnt)
+	wg := sync.WaitGroup{}
+	wg.Add(cnt)
+	for i := 0; i < cnt; i++ {
+		go func(i int) {
+			defer wg.Done()
+			v, err := lazy.get()
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+	wg.Wait()
+	for _, v := range results {
+		if v != 3 {
+			t.Fatalf("got the value of init call %d, want every get to see call 3", v)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("init ran %d times, want 3", calls)
+	}
+
+	lazy.reset()
+	if v, _ := lazy.get(); v != 4 {
+		t.Fatal("reset did not run init again")
+	}
+
+	if getInstance() != getInstanceByOnce() {
+		t.Fatal("getInstance and getInstanceByOnce differ")
+	}
+}
+
+// conn stands in for a per-key resource such as a database connection.
+type conn struct {
+	dsn    string
+	closed *[]string
+}
+
+func (c *conn) Close() error {
+	*c.closed = append(*c.closed, c.dsn)
+	if c.dsn == "broken" {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	var mu sync.Mutex
+	created := map[string]int{}
+	var closed []string
+	registry := newRegistry(func(dsn string) (*conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created[dsn]++
+		if dsn == "flaky" && created[dsn] == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &conn{dsn: dsn, closed: &closed}, nil
+	})
+
+	cnt := 30
+	wg := sync.WaitGroup{}
+	wg.Add(cnt)
+	for i := 0; i < cnt; i++ {
+		go func(i int) {
+			defer wg.Done()
+			dsn := []string{"tenant-a", "tenant-b", "tenant-c"}[i%3]
+			if c, err := registry.get(dsn); err != nil || c.dsn != dsn {
+				t.Errorf("get %s: %v %v", dsn, c, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, dsn := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		if created[dsn] != 1 {
+			t.Fatalf("%s created %d times, want once", dsn, created[dsn])
+		}
+	}
+	a, _ := registry.get("tenant-a")
+	if again, _ := registry.get("tenant-a"); again != a {
+		t.Fatal("same key gave a different instance")
+	}
+
+	if _, err := registry.get("flaky"); err == nil {
+		t.Fatal("failed create not reported")
+	}
+	if _, err := registry.get("flaky"); err != nil {
+		t.Fatalf("create not retried: %v", err)
+	}
+
+	if err := registry.dispose("tenant-b"); err != nil || len(closed) != 1 || closed[0] != "tenant-b" {
+		t.Fatalf("dispose closed %v, %v", closed, err)
+	}
+	if b, _ := registry.get("tenant-b"); b == nil || created["tenant-b"] != 2 {
+		t.Fatal("disposed key not created again")
+	}
+	registry.get("broken")
+
+	closed = nil
+	order := registry.keys()
+	err := registry.Close()
+	if err == nil || !strings.Contains(err.Error(), "close broken") {
+		t.Fatalf("got %v, want the failed close reported", err)
+	}
+	if len(closed) != len(order) {
+		t.Fatalf("closed %v, want all of %v", closed, order)
+	}
+	for i, dsn := range closed {
+		if dsn != order[len(order)-1-i] {
+			t.Fatalf("closed in order %v, want the reverse of %v", closed, order)
+		}
+	}
+	if _, err := registry.get("tenant-a"); !errors.Is(err, ErrRegistryClosed) {
+		t.Fatalf("got %v after Close, want ErrRegistryClosed", err)
+	}
+}
+
+// closeLog records the order things are closed in.
+type closeLog struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (l *closeLog) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = append(l.closed, name)
+}
+
+type config struct{ log *closeLog }
+
+func (c *config) Close() error { c.log.add("config"); return nil }
+
+type database struct{ config *config }
+
+func (d *database) Close() error { d.config.log.add("database"); return nil }
+
+type session struct {
+	db *database
+	id int
+}
+
+func (s *session) Close() error { s.db.config.log.add(fmt.Sprintf("session %d", s.id)); return nil }
+
+type handler struct{ session *session }
+
+type cycleA struct{}
+type cycleB struct{}
+
+func TestContainer(t *testing.T) {
+	log := &closeLog{}
+	c := newContainer()
+	var builds sync.Map
+	count := func(name string) {
+		n, _ := builds.LoadOrStore(name, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+	}
+	provideSingleton(c, func(*Scope) (*config, error) {
+		count("config")
+		return &config{log: log}, nil
+	})
+	provideSingleton(c, func(s *Scope) (*database, error) {
+		count("database")
+		cfg, err := resolve[*config](s)
+		return &database{config: cfg}, err
+	})
+	nextSession := int32(0)
+	provideScoped(c, func(s *Scope) (*session, error) {
+		db, err := resolve[*database](s)
+		id := int(atomic.AddInt32(&nextSession, 1))
+		return &session{db: db, id: id}, err
+	})
+	provideTransient(c, func(s *Scope) (*handler, error) {
+		sess, err := resolve[*session](s)
+		return &handler{session: sess}, err
+	})
+
+	cnt := 30
+	wg := sync.WaitGroup{}
+	wg.Add(cnt)
+	for i := 0; i < cnt; i++ {
+		go func() {
+			defer wg.Done()
+			scope := c.newScope()
+			h, err := resolve[*handler](scope)
+			if err != nil || h.session.db.config.log != log {
+				t.Errorf("resolve handler: %v", err)
+			}
+			scope.Close()
+		}()
+	}
+	wg.Wait()
+	for _, name := range []string{"config", "database"} {
+		if n, _ := builds.Load(name); *n.(*int32) != 1 {
+			t.Fatalf("%s built %d times, want once", name, *n.(*int32))
+		}
+	}
+	if len(log.closed) != cnt {
+		t.Fatalf("closed %d sessions, want %d", len(log.closed), cnt)
+	}
+
+	scope := c.newScope()
+	first, _ := resolve[*handler](scope)
+	second, _ := resolve[*handler](scope)
+	if first == second || first.session != second.session {
+		t.Fatal("want a new handler each time sharing the scope's session")
+	}
+	other, _ := resolve[*session](c.newScope())
+	if other == first.session {
+		t.Fatal("two scopes share a session")
+	}
+
+	provideSingleton(c, func(s *Scope) (*cycleA, error) {
+		_, err := resolve[*cycleB](s)
+		return &cycleA{}, err
+	})
+	provideSingleton(c, func(s *Scope) (*cycleB, error) {
+		_, err := resolve[*cycleA](s)
+		return &cycleB{}, err
+	})
+	_, err := resolve[*cycleA](c.root)
+	var cycle *CycleError
+	if !errors.Is(err, ErrDependencyCycle) || !errors.As(err, &cycle) ||
+		cycle.Error() != "dependency cycle: *Singleton.cycleA -> *Singleton.cycleB -> *Singleton.cycleA" {
+		t.Fatalf("got %v, want the cycle reported", err)
+	}
+
+	// each end is being built when it asks for the other, so neither may wait
+	both := newContainer()
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	var onceA, onceB sync.Once
+	meet := func() {
+		arrived.Done()
+		arrived.Wait()
+	}
+	provideSingleton(both, func(s *Scope) (*cycleA, error) {
+		onceA.Do(meet)
+		_, err := resolve[*cycleB](s)
+		return &cycleA{}, err
+	})
+	provideSingleton(both, func(s *Scope) (*cycleB, error) {
+		onceB.Do(meet)
+		_, err := resolve[*cycleA](s)
+		return &cycleB{}, err
+	})
+	errs := make(chan error, 2)
+	go func() {
+		_, err := resolve[*cycleA](both.newScope())
+		errs <- err
+	}()
+	go func() {
+		_, err := resolve[*cycleB](both.newScope())
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrDependencyCycle) {
+				t.Fatalf("got %v, want the cycle reported", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("concurrent resolves of a cycle deadlocked")
+		}
+	}
+
+	if _, err := resolve[*testing.T](c.root); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want not registered", err)
+	}
+
+	log.closed = nil
+	resolve[*session](c.root)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	want := []string{fmt.Sprintf("session %d", atomic.LoadInt32(&nextSession)), "database", "config"}
+	if fmt.Sprint(log.closed) != fmt.Sprint(want) {
+		t.Fatalf("closed %v, want %v", log.closed, want)
+	}
+	if _, err := resolve[*handler](scope); !errors.Is(err, ErrContainerClosed) {
+		t.Fatalf("got %v after Close, want ErrContainerClosed", err)
+	}
+}
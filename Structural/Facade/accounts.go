@@ -0,0 +1,64 @@
+package Facade
+
+// AccountRecord is what is kept of an account across restarts, the security
+// code only as its salted hash. The balance is not part of it, that is
+// replayed from the ledger.
+type AccountRecord struct {
+	AccountID string `json:"account_id"`
+	Currency  string `json:"currency"`
+	Salt      []byte `json:"salt"`
+	Hash      []byte `json:"hash"`
+	Closed    bool   `json:"closed"`
+}
+
+// accountStore keeps account records somewhere that outlives the process.
+// A record is appended whenever an account changes, the last one for an
+// account wins.
+type accountStore interface {
+	load() ([]AccountRecord, error)
+	append(records []AccountRecord) error
+}
+
+// newFileAccountStore opens (or creates) a JSON lines file of account
+// records, to be kept next to the ledger file.
+func newFileAccountStore(path string) (*fileStore[AccountRecord], error) {
+	return openFileStore[AccountRecord](path)
+}
+
+func (a *walletAccount) record() AccountRecord {
+	return AccountRecord{
+		AccountID: a.account.name,
+		Currency:  a.wallet.balance.currency,
+		Salt:      a.securityCode.salt,
+		Hash:      a.securityCode.hash,
+		Closed:    a.account.closed,
+	}
+}
+
+// restoreAccounts opens the accounts saved in store, with their balances from
+// the ledger, and saves every account opened or changed afterwards to store.
+// Call it after withLockout, so the restored codes get the same policy.
+func (w *WalletFacade) restoreAccounts(store accountStore) error {
+	records, err := store.load()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range records {
+		wallet := newWallet(r.Currency)
+		wallet.balance = w.ledger.balance(r.AccountID, r.Currency)
+		w.accounts[r.AccountID] = &walletAccount{
+			account:      &Account{name: r.AccountID, closed: r.Closed},
+			securityCode: restoreSecurityCode(r.Salt, r.Hash, w.lockout),
+			wallet:       wallet,
+		}
+	}
+	w.accountStore = store
+	return nil
+}
+
+// save must be called with the account's mu held, or before it is shared.
+func (w *WalletFacade) save(acc *walletAccount) error {
+	return w.accountStore.append([]AccountRecord{acc.record()})
+}
@@ -0,0 +1,304 @@
+package Facade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// api.go: serves the facade as a JSON over HTTP service
+//
+//	POST /accounts                       {"account_id", "security_code", "currency"}
+//	POST /accounts/{id}/credit           {"amount", "currency"}
+//	POST /accounts/{id}/debit            {"amount", "currency"}
+//	POST /accounts/{id}/transfer         {"to", "amount", "currency"}
+//	GET  /accounts/{id}/balance
+//	GET  /accounts/{id}/history?from=&to=&format=json|csv|text
+//
+// Everything below /accounts/{id} needs the X-Security-Code header. Amounts
+// are decimal strings, currency defaults to the wallet's. An Idempotency-Key
+// header is passed on to credit, debit and transfer, which answer 200 with a
+// warning if the money moved but the notification failed.
+type WalletAPI struct {
+	facade *WalletFacade
+}
+
+func newWalletAPI(facade *WalletFacade) *WalletAPI {
+	return &WalletAPI{
+		facade: facade,
+	}
+}
+
+type createAccountRequest struct {
+	AccountID    string `json:"account_id"`
+	SecurityCode *int   `json:"security_code"`
+	Currency     string `json:"currency"`
+}
+
+type moneyRequest struct {
+	To       string `json:"to,omitempty"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// balanceResponse carries a Warning when the money moved but something after
+// that failed, such as a notification. Retrying would move it again.
+type balanceResponse struct {
+	AccountID string `json:"account_id"`
+	Balance   string `json:"balance"`
+	Currency  string `json:"currency"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (a *WalletAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+	switch {
+	case len(parts) == 1 && parts[0] == "accounts":
+		if allowMethod(w, r, http.MethodPost) {
+			a.createAccount(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "accounts":
+		a.serveAccount(w, r, parts[1], parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (a *WalletAPI) serveAccount(w http.ResponseWriter, r *http.Request, accountID, action string) {
+	method := http.MethodPost
+	if action == "balance" || action == "history" {
+		method = http.MethodGet
+	}
+	switch action {
+	case "credit", "debit", "transfer", "balance", "history":
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if !allowMethod(w, r, method) {
+		return
+	}
+	code, err := strconv.Atoi(r.Header.Get("X-Security-Code"))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "X-Security-Code header is required")
+		return
+	}
+	// getBalance checks the security code and tells the wallet's currency
+	balance, err := a.facade.getBalance(accountID, code)
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+
+	switch action {
+	case "balance":
+		writeJSON(w, http.StatusOK, balanceResponse{accountID, balance.decimal(), balance.currency, ""})
+	case "history":
+		a.history(w, r, accountID, code)
+	default:
+		a.moveMoney(w, r, accountID, code, action, balance.currency)
+	}
+}
+
+func (a *WalletAPI) createAccount(w http.ResponseWriter, r *http.Request) {
+	var req createAccountRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.AccountID == "" {
+		writeError(w, http.StatusBadRequest, "account_id is required")
+		return
+	}
+	// a missing code would otherwise decode as 0
+	if req.SecurityCode == nil {
+		writeError(w, http.StatusBadRequest, "security_code is required")
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	err := a.facade.createAccount(req.AccountID, *req.SecurityCode, req.Currency)
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+	balance, err := a.facade.getBalance(req.AccountID, *req.SecurityCode)
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, balanceResponse{req.AccountID, balance.decimal(), balance.currency, ""})
+}
+
+func (a *WalletAPI) moveMoney(w http.ResponseWriter, r *http.Request, accountID string, code int, action, currency string) {
+	var req moneyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Currency != "" {
+		currency = req.Currency
+	}
+	amount, err := parseMoney(req.Amount, currency)
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	switch action {
+	case "credit":
+		err = a.facade.addMoneyToWallet(accountID, code, amount, key)
+	case "debit":
+		err = a.facade.deductMoneyFromWallet(accountID, code, amount, key)
+	case "transfer":
+		if req.To == "" {
+			writeError(w, http.StatusBadRequest, "to is required")
+			return
+		}
+		err = a.facade.transfer(accountID, code, req.To, amount, key)
+	}
+	var committed *committedError
+	if err != nil && !errors.As(err, &committed) {
+		a.writeWalletError(w, err)
+		return
+	}
+	balance, err := a.facade.getBalance(accountID, code)
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+	warning := ""
+	if committed != nil {
+		warning = committed.Error()
+	}
+	writeJSON(w, http.StatusOK, balanceResponse{accountID, balance.decimal(), balance.currency, warning})
+}
+
+var statementContentTypes = map[string]string{
+	"json": "application/json",
+	"csv":  "text/csv; charset=utf-8",
+	"text": "text/plain; charset=utf-8",
+}
+
+func (a *WalletAPI) history(w http.ResponseWriter, r *http.Request, accountID string, code int) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := statementContentTypes[format]
+	if !ok {
+		a.writeWalletError(w, fmt.Errorf("%w %q", ErrUnknownFormat, format))
+		return
+	}
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time", name))
+			return
+		}
+		bounds[i] = t
+	}
+	statement, err := a.facade.statement(accountID, code, bounds[0], bounds[1])
+	if err != nil {
+		a.writeWalletError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	statement.export(w, format)
+}
+
+// statusOf maps a facade error to a status code, the first match wins.
+// An error joined from a failed rollback is checked first, since the
+// wallet may then be in a state the caller needs to hear about.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrRollbackFailed), errors.Is(err, ErrUnbalancedEntry):
+		return http.StatusInternalServerError
+	case errors.Is(err, ErrAccountNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidSecurityCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrSecurityCodeLocked):
+		return http.StatusLocked
+	case errors.Is(err, ErrRuleViolation):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAccountExists), errors.Is(err, ErrAccountClosed),
+		errors.Is(err, ErrBalanceNotZero), errors.Is(err, ErrIdempotencyKeyReused):
+		return http.StatusConflict
+	case errors.Is(err, ErrInsufficientFunds), errors.Is(err, ErrCurrencyMismatch), errors.Is(err, ErrNoExchangeRate):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrUnknownCurrency),
+		errors.Is(err, ErrSameAccount), errors.Is(err, ErrUnknownFormat),
+		errors.Is(err, ErrAccountReserved), errors.Is(err, ErrInvalidAccountID):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *WalletAPI) writeWalletError(w http.ResponseWriter, err error) {
+	var locked *SecurityCodeLockedError
+	if errors.As(err, &locked) {
+		now := time.Now
+		if a.facade.lockout.now != nil {
+			now = a.facade.lockout.now
+		}
+		wait := locked.Until.Sub(now()).Seconds()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait)))))
+	}
+	writeError(w, statusOf(err), err.Error())
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, errorResponse{message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// readJSON decodes the request body into v, or answers 400 and returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	return false
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -1,6 +1,12 @@
 package Facade
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
 
 // It’s easy to underestimate the complexities that happen behind the scenes when you order a pizza using your credit card.
 // There are dozens of subsystems that are acting in this process. Here’s just a shortlist of them:
@@ -14,148 +20,544 @@ import "fmt"
 // The client only needs to enter the card details, the security pin, the amount to pay, and the operation type.
 // The Facade directs further communications with various components without exposing the client to internal complexities.
 
+const maxAccountIDLength = 64
+
 // walletFacade.go: Facade
 type WalletFacade struct {
-	account      *Account
-	wallet       *Wallet
-	securityCode *SecurityCode
 	notification *Notification
 	ledger       *Ledger
+	rates        RateProvider
+	idempotency  *idempotencyStore
+	lockout      lockoutPolicy
+	rules        *RulesEngine
+	accountStore accountStore
+
+	mu       sync.RWMutex
+	accounts map[string]*walletAccount
+}
+
+// walletAccount groups the subsystems that belong to one account.
+// mu is held for the whole of an operation, from the account check to the
+// ledger entry, so concurrent debits can't both pass the balance check.
+type walletAccount struct {
+	mu           sync.Mutex
+	account      *Account
+	securityCode *SecurityCode
+	wallet       *Wallet
 }
 
 func newWalletFacade(accountID string, code int) *WalletFacade {
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger())
+	walletFacade.createAccount(accountID, code, defaultCurrency)
+	return walletFacade
+}
+
+// newWalletFacadeWithLedger starts a facade without accounts on top of ledger.
+func newWalletFacadeWithLedger(ledger *Ledger) *WalletFacade {
+	return &WalletFacade{
+		notification: newDefaultNotification(),
+		ledger:       ledger,
+		idempotency:  newIdempotencyStore(defaultIdempotencyRetention, nil),
+		lockout:      defaultLockout,
+		rules:        newRulesEngine(nil),
+		accountStore: &memoryStore[AccountRecord]{},
+		accounts:     make(map[string]*walletAccount),
+	}
+}
+
+func (w *WalletFacade) withNotification(notification *Notification) *WalletFacade {
+	w.notification = notification
+	return w
+}
+
+// withLockout applies to accounts created afterwards.
+func (w *WalletFacade) withLockout(maxAttempts int, cooldown time.Duration, now clock) *WalletFacade {
+	w.lockout = lockoutPolicy{maxAttempts: maxAttempts, cooldown: cooldown, now: now}
+	return w
+}
+
+// withIdempotencyRetention sets how long idempotency keys are remembered.
+func (w *WalletFacade) withIdempotencyRetention(retention time.Duration, now clock) *WalletFacade {
+	w.idempotency = newIdempotencyStore(retention, now)
+	return w
+}
+
+// withRates lets the facade accept money in a currency other than the wallet's.
+func (w *WalletFacade) withRates(rates RateProvider) *WalletFacade {
+	w.rates = rates
+	return w
+}
+
+// withRules checks every debit and outgoing transfer against rules first,
+// now is the clock the rules' periods and windows are measured with. Limits
+// are converted to each wallet's currency with the facade's rates.
+func (w *WalletFacade) withRules(now clock, rules ...Rule) *WalletFacade {
+	w.rules = newRulesEngine(now, rules...)
+	return w
+}
+
+// createAccount opens an empty wallet for accountID. An id the ledger already
+// has entries for stays taken: a facade over a file ledger gets its accounts
+// back from restoreAccounts, security code included.
+func (w *WalletFacade) createAccount(accountID string, code int, currency string) error {
 	fmt.Println("Starting create account")
-	walletFacacde := &WalletFacade{
+	if strings.HasPrefix(accountID, internalPrefix) {
+		return &AccountError{accountID, ErrAccountReserved}
+	}
+	if !validAccountID(accountID) {
+		return &AccountError{accountID, ErrInvalidAccountID}
+	}
+	if _, err := newMoney(0, currency); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.accounts[accountID]; ok || w.ledger.has(accountID) {
+		return &AccountError{accountID, ErrAccountExists}
+	}
+	acc := &walletAccount{
 		account:      newAccount(accountID),
-		securityCode: newSecurityCode(code),
-		wallet:       newWallet(),
-		notification: &Notification{},
-		ledger:       &Ledger{},
+		securityCode: newSecurityCodeWithPolicy(code, w.lockout),
+		wallet:       newWallet(currency),
+	}
+	if err := w.save(acc); err != nil {
+		return err
 	}
+	w.accounts[accountID] = acc
 	fmt.Println("Account created")
-	return walletFacacde
+	return nil
 }
 
-func (w *WalletFacade) addMoneyToWallet(accountID string, securityCode int, amount int) error {
-	fmt.Println("Starting add money to wallet")
-	err := w.account.checkAccount(accountID)
+// validAccountID keeps ids to characters that are safe in a URL path and in
+// a mail header, since they end up in both.
+func validAccountID(accountID string) bool {
+	if accountID == "" || accountID == "." || accountID == ".." || len(accountID) > maxAccountIDLength {
+		return false
+	}
+	for _, r := range accountID {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// closeAccount only closes empty wallets, the id stays taken afterwards.
+func (w *WalletFacade) closeAccount(accountID string, securityCode int) error {
+	fmt.Println("Starting close account")
+	acc, unlock, err := w.acquire(accountID)
 	if err != nil {
 		return err
 	}
-	err = w.securityCode.checkCode(securityCode)
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
 	if err != nil {
 		return err
 	}
-	w.wallet.creditBalance(amount)
-	w.notification.sendWalletCreditNotification()
-	w.ledger.makeEntry(accountID, "credit", amount)
+	if acc.wallet.balance.amount != 0 {
+		return &AccountError{accountID, ErrBalanceNotZero}
+	}
+	acc.account.close()
+	if err := w.save(acc); err != nil {
+		acc.account.closed = false
+		return err
+	}
+	fmt.Println("Account closed")
 	return nil
 }
 
-func (w *WalletFacade) deductMoneyFromWallet(accountID string, securityCode int, amount int) error {
-	fmt.Println("Starting debit money from wallet")
-	err := w.account.checkAccount(accountID)
+func (w *WalletFacade) changeSecurityCode(accountID string, oldCode, newCode int) error {
+	fmt.Println("Starting change security code")
+	acc, unlock, err := w.acquire(accountID)
 	if err != nil {
 		return err
 	}
-
-	err = w.securityCode.checkCode(securityCode)
+	defer unlock()
+	err = acc.account.checkAccount(accountID)
 	if err != nil {
 		return err
 	}
-	err = w.wallet.debitBalance(amount)
+	salt, hash := acc.securityCode.salt, acc.securityCode.hash
+	err = acc.securityCode.rotateCode(oldCode, newCode)
 	if err != nil {
 		return err
 	}
-	w.notification.sendWalletDebitNotification()
-	w.ledger.makeEntry(accountID, "debit", amount)
+	if err := w.save(acc); err != nil {
+		acc.securityCode.salt, acc.securityCode.hash = salt, hash
+		return err
+	}
 	return nil
 }
 
-//  account.go: Complex subsystem parts
+func (w *WalletFacade) securityAudit(accountID string, securityCode int) ([]SecurityEvent, error) {
+	acc, unlock, err := w.acquire(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
+	if err != nil {
+		return nil, err
+	}
+	return acc.securityCode.auditTrail(), nil
+}
 
-type Account struct {
-	name string
+func (w *WalletFacade) getBalance(accountID string, securityCode int) (Money, error) {
+	acc, unlock, err := w.acquire(accountID)
+	if err != nil {
+		return Money{}, err
+	}
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
+	if err != nil {
+		return Money{}, err
+	}
+	return acc.wallet.balance, nil
 }
 
-func newAccount(accountName string) *Account {
-	return &Account{
-		name: accountName,
+// statement reads the account's transactions with from <= time < to from the ledger,
+// see Statement.export for the formats.
+func (w *WalletFacade) statement(accountID string, securityCode int, from, to time.Time) (*Statement, error) {
+	acc, unlock, err := w.acquire(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
+	if err != nil {
+		return nil, err
 	}
+	return newStatement(w.ledger, accountID, acc.wallet.balance.currency, from, to), nil
 }
 
-func (a *Account) checkAccount(accountName string) error {
-	if a.name != accountName {
-		return fmt.Errorf("Account Name is incorrect")
+// addMoneyToWallet, deductMoneyFromWallet and transfer take an idempotency key:
+// repeating a call with the same key returns the first result without moving
+// money again. Pass "" to opt out.
+//
+// addMoneyToWallet and deductMoneyFromWallet convert amount to the wallet's
+// currency first, which needs withRates if the currencies differ.
+func (w *WalletFacade) addMoneyToWallet(accountID string, securityCode int, amount Money, idempotencyKey string) error {
+	return w.idempotency.do(idempotencyKey, fmt.Sprintf("credit %s %s", accountID, amount), func() error {
+		return w.credit(accountID, securityCode, amount)
+	})
+}
+
+func (w *WalletFacade) deductMoneyFromWallet(accountID string, securityCode int, amount Money, idempotencyKey string) error {
+	return w.idempotency.do(idempotencyKey, fmt.Sprintf("debit %s %s", accountID, amount), func() error {
+		return w.debit(accountID, securityCode, amount)
+	})
+}
+
+func (w *WalletFacade) transfer(fromID string, securityCode int, toID string, amount Money, idempotencyKey string) error {
+	return w.idempotency.do(idempotencyKey, fmt.Sprintf("transfer %s %s %s", fromID, toID, amount), func() error {
+		return w.move(fromID, securityCode, toID, amount)
+	})
+}
+
+func (w *WalletFacade) credit(accountID string, securityCode int, amount Money) error {
+	fmt.Println("Starting add money to wallet")
+	event, err := w.bookCredit(accountID, securityCode, amount)
+	if err != nil {
+		return err
 	}
-	fmt.Println("Account Verified")
-	return nil
+	return w.notify(func() error { return w.notification.sendWalletCreditNotification(event) })
+}
+
+// bookCredit, bookDebit and bookTransfer change the wallets and the ledger
+// while holding the accounts' locks and return what to notify about.
+func (w *WalletFacade) bookCredit(accountID string, securityCode int, amount Money) (walletEvent, error) {
+	acc, unlock, err := w.acquire(accountID)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	amount, err = convert(amount, acc.wallet.balance.currency, w.rates)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	tx := &unitOfWork{}
+	err = tx.do(
+		func() error { return acc.wallet.creditBalance(amount) },
+		func() error { return acc.wallet.debitBalance(amount) },
+	)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	err = w.record(tx, func() (string, error) { return w.ledger.makeEntry(accountID, "credit", amount) })
+	if err != nil {
+		return walletEvent{}, err
+	}
+	return walletEvent{accountID, "credit", amount, acc.wallet.balance}, nil
+}
+
+func (w *WalletFacade) debit(accountID string, securityCode int, amount Money) error {
+	fmt.Println("Starting debit money from wallet")
+	event, err := w.bookDebit(accountID, securityCode, amount)
+	if err != nil {
+		return err
+	}
+	return w.notify(func() error { return w.notification.sendWalletDebitNotification(event) })
 }
 
-//  securityCode.go: Complex subsystem parts
+func (w *WalletFacade) bookDebit(accountID string, securityCode int, amount Money) (walletEvent, error) {
+	acc, unlock, err := w.acquire(accountID)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	defer unlock()
+	err = acc.verify(accountID, securityCode)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	amount, err = convert(amount, acc.wallet.balance.currency, w.rates)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	tx := &unitOfWork{}
+	err = w.admit(tx, accountID, "", amount)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	err = tx.do(
+		func() error { return acc.wallet.debitBalance(amount) },
+		func() error { return acc.wallet.creditBalance(amount) },
+	)
+	if err != nil {
+		return walletEvent{}, err
+	}
+	err = w.record(tx, func() (string, error) { return w.ledger.makeEntry(accountID, "debit", amount) })
+	if err != nil {
+		return walletEvent{}, err
+	}
+	return walletEvent{accountID, "debit", amount, acc.wallet.balance}, nil
+}
 
-type SecurityCode struct {
-	code int
+// move transfers amount from one wallet to another. If any step fails, both
+// wallets and the ledger are rolled back, notifications are only sent once
+// both sides are booked. amount is taken from the sender in the sender's
+// currency and arrives converted to the receiver's.
+func (w *WalletFacade) move(fromID string, securityCode int, toID string, amount Money) error {
+	fmt.Println("Starting transfer")
+	if fromID == toID {
+		return ErrSameAccount
+	}
+	sent, received, err := w.bookTransfer(fromID, securityCode, toID, amount)
+	if err != nil {
+		return err
+	}
+	return w.notify(func() error {
+		return w.notification.sendWalletDebitNotification(sent)
+	}, func() error {
+		return w.notification.sendWalletCreditNotification(received)
+	})
 }
 
-func newSecurityCode(code int) *SecurityCode {
-	return &SecurityCode{
-		code: code,
+func (w *WalletFacade) bookTransfer(fromID string, securityCode int, toID string, amount Money) (walletEvent, walletEvent, error) {
+	from, to, unlock, err := w.acquirePair(fromID, toID)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	defer unlock()
+	err = from.verify(fromID, securityCode)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	err = to.account.checkAccount(toID)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
 	}
+	sent, err := convert(amount, from.wallet.balance.currency, w.rates)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	received, err := convert(sent, to.wallet.balance.currency, w.rates)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	tx := &unitOfWork{}
+	err = w.admit(tx, fromID, toID, sent)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	err = tx.do(
+		func() error { return from.wallet.debitBalance(sent) },
+		func() error { return from.wallet.creditBalance(sent) },
+	)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	err = tx.do(
+		func() error { return to.wallet.creditBalance(received) },
+		func() error { return to.wallet.debitBalance(received) },
+	)
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	err = w.record(tx, func() (string, error) { return w.ledger.makeTransferEntry(fromID, toID, sent, received) })
+	if err != nil {
+		return walletEvent{}, walletEvent{}, err
+	}
+	return walletEvent{fromID, "transfer", sent, from.wallet.balance}, walletEvent{toID, "transfer", received, to.wallet.balance}, nil
 }
 
-func (s *SecurityCode) checkCode(incomingCode int) error {
-	if s.code != incomingCode {
-		return fmt.Errorf("Security Code is incorrect")
+// notify sends the notifications of a change once it can no longer be rolled
+// back, and after the accounts are unlocked, so a slow channel doesn't hold
+// up other operations on them. Every send is tried, a failure is returned as
+// a committedError.
+func (w *WalletFacade) notify(sends ...func() error) error {
+	var errs []error
+	for _, send := range sends {
+		errs = append(errs, send())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return &committedError{err}
 	}
-	fmt.Println("SecurityCode Verified")
 	return nil
 }
 
-// wallet.go: Complex subsystem parts
-type Wallet struct {
-	balance int
+// admit runs the spending rules as a step of tx, a rolled back debit doesn't count towards limits.
+func (w *WalletFacade) admit(tx *unitOfWork, fromID, toID string, amount Money) error {
+	var attempt debitAttempt
+	return tx.do(func() (err error) {
+		attempt, err = w.rules.admit(fromID, toID, amount, w.rates)
+		return err
+	}, func() error {
+		w.rules.forget(attempt)
+		return nil
+	})
 }
 
-func newWallet() *Wallet {
-	return &Wallet{
-		balance: 0,
+// record writes to the ledger as the last step of tx, so nothing after it can
+// fail and the entry never needs undoing. A failed write rolls back the rest.
+func (w *WalletFacade) record(tx *unitOfWork, write func() (string, error)) error {
+	return tx.do(func() error {
+		_, err := write()
+		return err
+	}, nil)
+}
+
+func (w *WalletFacade) lookup(accountID string) (*walletAccount, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	acc, ok := w.accounts[accountID]
+	if !ok {
+		return nil, &AccountError{accountID, ErrAccountNotFound}
 	}
+	return acc, nil
 }
 
-func (w *Wallet) creditBalance(amount int) {
-	w.balance += amount
-	fmt.Println("Wallet balance added successfully")
-	return
+// acquire finds the account and locks it, call unlock when done.
+func (w *WalletFacade) acquire(accountID string) (*walletAccount, func(), error) {
+	acc, err := w.lookup(accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	acc.mu.Lock()
+	return acc, acc.mu.Unlock, nil
 }
 
-func (w *Wallet) debitBalance(amount int) error {
-	if w.balance < amount {
-		return fmt.Errorf("Balance is not sufficient")
+// acquirePair locks both accounts in id order, so two transfers going
+// opposite ways between the same accounts can't deadlock.
+func (w *WalletFacade) acquirePair(firstID, secondID string) (*walletAccount, *walletAccount, func(), error) {
+	first, err := w.lookup(firstID)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	fmt.Println("Wallet balance is Sufficient")
-	w.balance = w.balance - amount
-	return nil
+	second, err := w.lookup(secondID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	a, b := first, second
+	if secondID < firstID {
+		a, b = second, first
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return first, second, func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}, nil
 }
 
-//  ledger.go: Complex subsystem parts
+// verify checks the account is open and the security code matches, mu must be held.
+func (a *walletAccount) verify(accountID string, securityCode int) error {
+	err := a.account.checkAccount(accountID)
+	if err != nil {
+		return err
+	}
+	return a.securityCode.checkCode(securityCode)
+}
+
+//  account.go: Complex subsystem parts
+
+type Account struct {
+	name   string
+	closed bool
+}
+
+func newAccount(accountName string) *Account {
+	return &Account{
+		name: accountName,
+	}
+}
+
+func (a *Account) checkAccount(accountName string) error {
+	if a.name != accountName {
+		return &AccountError{accountName, ErrAccountNotFound}
+	}
+	if a.closed {
+		return &AccountError{accountName, ErrAccountClosed}
+	}
+	fmt.Println("Account Verified")
+	return nil
+}
 
-type Ledger struct {
+func (a *Account) close() {
+	a.closed = true
 }
 
-func (s *Ledger) makeEntry(accountID, txnType string, amount int) {
-	fmt.Printf("Make ledger entry for accountId %s with txnType %s for amount %d\n", accountID, txnType, amount)
-	return
+// wallet.go: Complex subsystem parts
+type Wallet struct {
+	balance Money
 }
 
-// notification.go: Complex subsystem parts
-type Notification struct {
+func newWallet(currency string) *Wallet {
+	return &Wallet{
+		balance: Money{currency: currency},
+	}
 }
 
-func (n *Notification) sendWalletCreditNotification() {
-	fmt.Println("Sending wallet credit notification")
+func (w *Wallet) creditBalance(amount Money) error {
+	if !amount.isPositive() {
+		return fmt.Errorf("%w: %s is not positive", ErrInvalidAmount, amount)
+	}
+	balance, err := w.balance.add(amount)
+	if err != nil {
+		return err
+	}
+	w.balance = balance
+	fmt.Println("Wallet balance added successfully")
+	return nil
 }
 
-func (n *Notification) sendWalletDebitNotification() {
-	fmt.Println("Sending wallet debit notification")
+func (w *Wallet) debitBalance(amount Money) error {
+	if !amount.isPositive() {
+		return fmt.Errorf("%w: %s is not positive", ErrInvalidAmount, amount)
+	}
+	balance, err := w.balance.sub(amount)
+	if err != nil {
+		return err
+	}
+	if balance.amount < 0 {
+		return &InsufficientFundsError{Requested: amount, Available: w.balance}
+	}
+	fmt.Println("Wallet balance is Sufficient")
+	w.balance = balance
+	return nil
 }
@@ -1,23 +1,48 @@
 package Facade
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+func money(amount, currency string) Money {
+	m, err := parseMoney(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func usd(amount string) Money {
+	return money(amount, "USD")
+}
+
 func TestFacade(t *testing.T) {
 	fmt.Println()
 	walletFacade := newWalletFacade("abc", 1234)
 	fmt.Println()
 
-	err := walletFacade.addMoneyToWallet("abc", 1234, 10)
+	err := walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "")
 	if err != nil {
 		log.Fatalf("Error: %s\n", err.Error())
 	}
 
 	fmt.Println()
-	err = walletFacade.deductMoneyFromWallet("abc", 1234, 5)
+	err = walletFacade.deductMoneyFromWallet("abc", 1234, usd("5"), "")
 	if err != nil {
 		log.Fatalf("Error: %s\n", err.Error())
 	}
@@ -30,13 +55,1094 @@ func TestFacade(t *testing.T) {
 	// Account Verified
 	// SecurityCode Verified
 	// Wallet balance added successfully
+	// Make ledger entry for accountId abc with txnType credit for amount 10.00 USD
 	// Sending wallet credit notification
-	// Make ledger entry for accountId abc with txnType credit for amount 10
 	//
 	// Starting debit money from wallet
 	// Account Verified
 	// SecurityCode Verified
 	// Wallet balance is Sufficient
+	// Make ledger entry for accountId abc with txnType debit for amount 5.00 USD
 	// Sending wallet debit notification
-	// Make ledger entry for accountId abc with txnType debit for amount 5
+}
+
+func TestLedger(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	ledger, err := newLedger(&memoryStore[LedgerEntry]{}, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("newLedger: %v", err)
+	}
+	ledger.makeEntry("abc", "credit", usd("10"))
+	now = now.Add(time.Hour)
+	ledger.makeEntry("abc", "debit", usd("3"))
+	now = now.Add(time.Hour)
+	ledger.makeEntry("xyz", "credit", usd("7"))
+
+	if got := ledger.balance("abc", "USD"); got != usd("7") {
+		t.Fatalf("abc balance %s, want 7.00 USD", got)
+	}
+	total := ledger.balance(externalAccount, "USD").amount + ledger.balance("abc", "USD").amount + ledger.balance("xyz", "USD").amount
+	if total != 0 {
+		t.Fatalf("ledger does not balance, total %d", total)
+	}
+	if _, err := ledger.post("broken", posting{"abc", usd("1")}); err == nil {
+		t.Fatal("unbalanced transaction accepted")
+	}
+	if _, err := ledger.post("broken", posting{"abc", usd("1")}, posting{"xyz", money("-1", "EUR")}); err == nil {
+		t.Fatal("transaction balanced across currencies accepted")
+	}
+
+	entries := ledger.entriesFor("abc", time.Time{}, time.Time{})
+	if len(entries) != 2 || entries[0].Balance != 1000 || entries[1].Balance != 700 || entries[1].Amount != -300 {
+		t.Fatalf("unexpected abc entries %+v", entries)
+	}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if got := ledger.entriesFor("abc", start, start.Add(time.Hour)); len(got) != 1 || got[0].TxnType != "debit" {
+		t.Fatalf("range query got %+v, want the debit only", got)
+	}
+	entries[0].Amount = 1000
+	if ledger.entriesFor("abc", time.Time{}, time.Time{})[0].Amount != 1000 {
+		t.Fatal("entry changed through a returned copy")
+	}
+}
+
+func TestFileLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, err := newFileLedger(path)
+	if err != nil {
+		t.Fatalf("newFileLedger: %v", err)
+	}
+	accountsPath := filepath.Join(t.TempDir(), "accounts.jsonl")
+	accounts, err := newFileAccountStore(accountsPath)
+	if err != nil {
+		t.Fatalf("newFileAccountStore: %v", err)
+	}
+	walletFacade := newWalletFacadeWithLedger(ledger)
+	if err := walletFacade.restoreAccounts(accounts); err != nil {
+		t.Fatalf("restoreAccounts: %v", err)
+	}
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "")
+	walletFacade.deductMoneyFromWallet("abc", 1234, usd("4"), "")
+	walletFacade.createAccount("old", 4321, "EUR")
+	walletFacade.closeAccount("old", 4321)
+	walletFacade.changeSecurityCode("abc", 1234, 5678)
+	ledger.Close()
+	accounts.Close()
+
+	// simulate a crash in the middle of writing the next entry
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.WriteString(`{"seq":5,"txn_id":"tx`)
+	f.Close()
+
+	ledger, err = newFileLedger(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer ledger.Close()
+	if got := len(ledger.entriesFor("abc", time.Time{}, time.Time{})); got != 2 {
+		t.Fatalf("got %d entries after restart, want 2", got)
+	}
+	walletFacade = newWalletFacadeWithLedger(ledger)
+	if err := walletFacade.createAccount("abc", 9999, "USD"); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("got %v, want an id with ledger entries to stay taken", err)
+	}
+	accounts, err = newFileAccountStore(accountsPath)
+	if err != nil {
+		t.Fatalf("reopen accounts: %v", err)
+	}
+	defer accounts.Close()
+	if err := walletFacade.restoreAccounts(accounts); err != nil {
+		t.Fatalf("restoreAccounts: %v", err)
+	}
+	if _, err := walletFacade.getBalance("abc", 1234); !errors.Is(err, ErrInvalidSecurityCode) {
+		t.Fatalf("got %v, want the rotated code kept", err)
+	}
+	if balance, _ := walletFacade.getBalance("abc", 5678); balance != usd("6") {
+		t.Fatalf("restored balance %s, want 6.00 USD", balance)
+	}
+	if _, err := walletFacade.getBalance("old", 4321); !errors.Is(err, ErrAccountClosed) {
+		t.Fatalf("got %v, want the closed account kept closed", err)
+	}
+	if err := walletFacade.deductMoneyFromWallet("abc", 5678, usd("6"), ""); err != nil {
+		t.Fatalf("debit after restart: %v", err)
+	}
+	if got := ledger.entriesFor("abc", time.Time{}, time.Time{}); got[len(got)-1].Seq != 5 {
+		t.Fatalf("sequence not continued after restart: %+v", got[len(got)-1])
+	}
+}
+
+func TestMultiAccountFacade(t *testing.T) {
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger())
+	mustNot := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	mustNot(walletFacade.createAccount("alice", 1111, "USD"))
+	mustNot(walletFacade.createAccount("bob", 2222, "USD"))
+	if err := walletFacade.createAccount("bob", 3333, "USD"); err == nil {
+		t.Fatal("duplicate account created")
+	}
+
+	mustNot(walletFacade.addMoneyToWallet("alice", 1111, usd("50"), ""))
+	mustNot(walletFacade.addMoneyToWallet("bob", 2222, usd("5"), ""))
+	if err := walletFacade.addMoneyToWallet("carol", 1111, usd("5"), ""); err == nil {
+		t.Fatal("credited an unknown account")
+	}
+
+	mustNot(walletFacade.transfer("alice", 1111, "bob", usd("20"), ""))
+	if err := walletFacade.transfer("alice", 2222, "bob", usd("1"), ""); err == nil {
+		t.Fatal("transfer with the receiver's security code accepted")
+	}
+	if err := walletFacade.transfer("alice", 1111, "bob", usd("31"), ""); err == nil {
+		t.Fatal("transfer over the balance accepted")
+	}
+	if err := walletFacade.transfer("alice", 1111, "carol", usd("1"), ""); err == nil {
+		t.Fatal("transfer to an unknown account accepted")
+	}
+	alice, _ := walletFacade.getBalance("alice", 1111)
+	bob, _ := walletFacade.getBalance("bob", 2222)
+	if alice != usd("30") || bob != usd("25") {
+		t.Fatalf("got balances alice=%s bob=%s, want 30 and 25", alice, bob)
+	}
+	if walletFacade.ledger.balance("alice", "USD") != alice || walletFacade.ledger.balance("bob", "USD") != bob {
+		t.Fatal("ledger and wallets disagree")
+	}
+
+	if err := walletFacade.closeAccount("bob", 2222); err == nil {
+		t.Fatal("closed an account with money in it")
+	}
+	mustNot(walletFacade.transfer("bob", 2222, "alice", usd("25"), ""))
+	mustNot(walletFacade.closeAccount("bob", 2222))
+	if err := walletFacade.transfer("alice", 1111, "bob", usd("1"), ""); err == nil {
+		t.Fatal("transfer to a closed account accepted")
+	}
+	if err := walletFacade.createAccount("bob", 2222, "USD"); err == nil {
+		t.Fatal("closed account id reused")
+	}
+}
+
+func TestMoney(t *testing.T) {
+	cases := []struct {
+		value, currency, want string
+		ok                    bool
+	}{
+		{"12.34", "USD", "12.34 USD", true},
+		{"0.5", "USD", "0.50 USD", true},
+		{"-3", "EUR", "-3.00 EUR", true},
+		{"1500", "JPY", "1500 JPY", true},
+		{"1.234", "KWD", "1.234 KWD", true},
+		{"1.234", "USD", "", false},
+		{"1.5", "JPY", "", false},
+		{"abc", "USD", "", false},
+		{"1", "XXX", "", false},
+	}
+	for _, c := range cases {
+		m, err := parseMoney(c.value, c.currency)
+		if (err == nil) != c.ok {
+			t.Errorf("parseMoney(%q, %s): got err %v", c.value, c.currency, err)
+			continue
+		}
+		if c.ok && m.String() != c.want {
+			t.Errorf("parseMoney(%q, %s) = %s, want %s", c.value, c.currency, m, c.want)
+		}
+	}
+	if _, err := usd("1").add(money("1", "EUR")); err == nil {
+		t.Error("added different currencies")
+	}
+	huge := usd("92233720368547758.07")
+	if _, err := huge.add(usd("0.01")); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("got %v, want an overflowing sum rejected", err)
+	}
+	if _, err := huge.neg().sub(usd("0.02")); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("got %v, want an underflowing difference rejected", err)
+	}
+	walletFacade := newWalletFacade("abc", 1234)
+	mustNot := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	mustNot(walletFacade.addMoneyToWallet("abc", 1234, huge, ""))
+	if err := walletFacade.addMoneyToWallet("abc", 1234, huge, ""); !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("got %v, want a credit past the largest balance rejected", err)
+	}
+	if _, err := walletFacade.ledger.makeEntry("abc", "credit", huge); !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("got %v, want the ledger to refuse an overflowing balance", err)
+	}
+	if balance, _ := walletFacade.getBalance("abc", 1234); balance != huge || walletFacade.ledger.balance("abc", "USD") != huge {
+		t.Fatalf("got balance %s, want %s kept", balance, huge)
+	}
+
+	rates := newStaticRates()
+	rates.set("EUR", "USD", "1.1")
+	rates.set("USD", "JPY", "150.255")
+	conversions := []struct {
+		from Money
+		to   string
+		want string
+	}{
+		{money("10", "EUR"), "USD", "11.00 USD"},
+		{usd("11"), "EUR", "10.00 EUR"},
+		{usd("0.01"), "JPY", "2 JPY"},
+		{usd("0.03"), "JPY", "5 JPY"},
+		{money("-10", "EUR"), "USD", "-11.00 USD"},
+	}
+	for _, c := range conversions {
+		got, err := convert(c.from, c.to, rates)
+		if err != nil || got.String() != c.want {
+			t.Errorf("convert(%s, %s) = %s, %v, want %s", c.from, c.to, got, err, c.want)
+		}
+	}
+	if _, err := convert(usd("1"), "GBP", rates); err == nil {
+		t.Error("converted without a rate")
+	}
+}
+
+func TestCurrencyWallets(t *testing.T) {
+	rates := newStaticRates()
+	rates.set("EUR", "USD", "1.1")
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger())
+	walletFacade.createAccount("us", 1, "USD")
+	walletFacade.createAccount("eu", 2, "EUR")
+
+	if err := walletFacade.addMoneyToWallet("us", 1, money("10", "EUR"), ""); err == nil {
+		t.Fatal("credited EUR to a USD wallet without rates")
+	}
+	if err := walletFacade.addMoneyToWallet("us", 1, usd("0"), ""); err == nil {
+		t.Fatal("credited zero")
+	}
+	if err := walletFacade.addMoneyToWallet("us", 1, usd("-5"), ""); err == nil {
+		t.Fatal("credited a negative amount")
+	}
+	if err := walletFacade.deductMoneyFromWallet("us", 1, usd("-5"), ""); err == nil {
+		t.Fatal("debited a negative amount")
+	}
+
+	walletFacade.withRates(rates)
+	if err := walletFacade.addMoneyToWallet("us", 1, money("10", "EUR"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if err := walletFacade.transfer("us", 1, "eu", usd("5.50"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	us, _ := walletFacade.getBalance("us", 1)
+	eu, _ := walletFacade.getBalance("eu", 2)
+	if us != usd("5.50") || eu != money("5", "EUR") {
+		t.Fatalf("got balances %s and %s, want 5.50 USD and 5.00 EUR", us, eu)
+	}
+	ledger := walletFacade.ledger
+	if ledger.balance(fxAccount, "USD") != usd("5.50") || ledger.balance(fxAccount, "EUR") != money("-5", "EUR") {
+		t.Fatal("exchange not booked against the fx account")
+	}
+	for _, id := range []string{fxAccount, externalAccount} {
+		if err := walletFacade.createAccount(id, 9, "USD"); !errors.Is(err, ErrAccountReserved) {
+			t.Fatalf("got %v, want %s reserved", err, id)
+		}
+	}
+}
+
+// TestConcurrentWallet is meant to be run with go test -race.
+func TestConcurrentWallet(t *testing.T) {
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger())
+	walletFacade.createAccount("alice", 1111, "USD")
+	walletFacade.createAccount("bob", 2222, "USD")
+	walletFacade.addMoneyToWallet("alice", 1111, usd("100"), "")
+	walletFacade.addMoneyToWallet("bob", 2222, usd("100"), "")
+
+	const workers = 50
+	var mu sync.Mutex
+	debited := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if walletFacade.deductMoneyFromWallet("alice", 1111, usd("1"), "") == nil {
+					mu.Lock()
+					debited++
+					mu.Unlock()
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			walletFacade.transfer("alice", 1111, "bob", usd("1"), "")
+		}()
+		go func() {
+			defer wg.Done()
+			walletFacade.transfer("bob", 2222, "alice", usd("1"), "")
+		}()
+	}
+	wg.Wait()
+
+	alice, _ := walletFacade.getBalance("alice", 1111)
+	bob, _ := walletFacade.getBalance("bob", 2222)
+	if alice.amount < 0 || bob.amount < 0 {
+		t.Fatalf("balance went negative: alice=%s bob=%s", alice, bob)
+	}
+	total, _ := alice.add(bob)
+	want, _ := usd("200").sub(Money{amount: int64(debited) * 100, currency: "USD"})
+	if total != want {
+		t.Fatalf("alice+bob = %s after %d debits, want %s", total, debited, want)
+	}
+	ledger := walletFacade.ledger
+	if ledger.balance("alice", "USD") != alice || ledger.balance("bob", "USD") != bob {
+		t.Fatal("ledger and wallets disagree")
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).withIdempotencyRetention(time.Hour, clock)
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "USD")
+
+	for i := 0; i < 3; i++ {
+		if err := walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "credit-1"); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	balance, _ := walletFacade.getBalance("abc", 1234)
+	if balance != usd("10") {
+		t.Fatalf("balance %s after retried credit, want 10.00 USD", balance)
+	}
+
+	// a rejected call leaves the key free, the retry runs
+	if err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("50"), "debit-1"); err == nil {
+		t.Fatal("overdraft accepted")
+	}
+	walletFacade.addMoneyToWallet("abc", 1234, usd("100"), "credit-2")
+	if err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("50"), "debit-1"); err != nil {
+		t.Fatalf("retry after topping up: %s", err)
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1111, usd("5"), "credit-3"); !errors.Is(err, ErrInvalidSecurityCode) {
+		t.Fatalf("got %v, want wrong PIN rejected", err)
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("5"), "credit-3"); err != nil {
+		t.Fatalf("retry with the right PIN: %s", err)
+	}
+	if err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("5"), "debit-1"); err == nil {
+		t.Fatal("key reused for a different amount accepted")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			walletFacade.transfer("abc", 1234, "xyz", usd("1"), "transfer-1")
+		}()
+	}
+	wg.Wait()
+	balance, _ = walletFacade.getBalance("xyz", 5678)
+	if balance != usd("1") {
+		t.Fatalf("balance %s after concurrent retries, want 1.00 USD", balance)
+	}
+
+	mu.Lock()
+	now = now.Add(time.Hour)
+	mu.Unlock()
+	walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "credit-1")
+	balance, _ = walletFacade.getBalance("abc", 1234)
+	if balance != usd("74") {
+		t.Fatalf("balance %s, want expired key to credit again", balance)
+	}
+}
+
+func TestSecurityCode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).
+		withLockout(3, 10*time.Minute, func() time.Time { return now })
+	walletFacade.createAccount("abc", 1234, "USD")
+
+	acc, _ := walletFacade.lookup("abc")
+	if bytes.Contains(acc.securityCode.hash, []byte("1234")) || len(acc.securityCode.salt) == 0 {
+		t.Fatal("security code not stored as a salted hash")
+	}
+	other := newSecurityCode(1234)
+	if bytes.Equal(other.hash, acc.securityCode.hash) {
+		t.Fatal("same code hashed to the same value, salt not used")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := walletFacade.addMoneyToWallet("abc", 1111, usd("1"), ""); err == nil {
+			t.Fatal("wrong code accepted")
+		}
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("1"), ""); err == nil {
+		t.Fatal("right code accepted while locked out")
+	}
+	now = now.Add(10 * time.Minute)
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("1"), ""); err != nil {
+		t.Fatalf("Error after cooldown: %s", err)
+	}
+
+	if err := walletFacade.changeSecurityCode("abc", 1111, 4321); err == nil {
+		t.Fatal("rotated with a wrong old code")
+	}
+	if err := walletFacade.changeSecurityCode("abc", 1234, 4321); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := walletFacade.getBalance("abc", 1234); err == nil {
+		t.Fatal("old code still accepted after rotation")
+	}
+	if _, err := walletFacade.getBalance("abc", 4321); err != nil {
+		t.Fatalf("new code rejected: %s", err)
+	}
+
+	audit, _ := walletFacade.securityAudit("abc", 4321)
+	var events []string
+	for _, e := range audit {
+		events = append(events, e.Event)
+	}
+	want := []string{
+		eventFailed, eventFailed, eventFailed, eventLocked, eventRejectedLocked,
+		eventFailed, eventRotated, eventFailed,
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Fatalf("got audit %v, want %v", events, want)
+	}
+	if _, err := walletFacade.securityAudit("abc", 1234); !errors.Is(err, ErrInvalidSecurityCode) {
+		t.Fatalf("got %v, want the audit trail refused to a wrong code", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).
+		withLockout(2, 10*time.Minute, func() time.Time { return now })
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "EUR")
+	walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "")
+
+	err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("25"), "")
+	var funds *InsufficientFundsError
+	if !errors.Is(err, ErrInsufficientFunds) || !errors.As(err, &funds) {
+		t.Fatalf("got %v, want insufficient funds", err)
+	}
+	if funds.Requested != usd("25") || funds.Available != usd("10") {
+		t.Fatalf("got requested %s available %s", funds.Requested, funds.Available)
+	}
+
+	var account *AccountError
+	if err := walletFacade.addMoneyToWallet("nobody", 1234, usd("1"), ""); !errors.Is(err, ErrAccountNotFound) || !errors.As(err, &account) || account.AccountID != "nobody" {
+		t.Fatalf("got %v, want account not found for nobody", err)
+	}
+	if err := walletFacade.createAccount("abc", 1234, "USD"); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("got %v, want account exists", err)
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("-1"), ""); !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("got %v, want invalid amount", err)
+	}
+	if err := walletFacade.transfer("abc", 1234, "abc", usd("1"), ""); !errors.Is(err, ErrSameAccount) {
+		t.Fatalf("got %v, want same account", err)
+	}
+	var mismatch *CurrencyMismatchError
+	if err := walletFacade.addMoneyToWallet("abc", 1234, money("1", "EUR"), ""); !errors.Is(err, ErrCurrencyMismatch) || !errors.As(err, &mismatch) || mismatch.Have != "EUR" || mismatch.Want != "USD" {
+		t.Fatalf("got %v, want currency mismatch", err)
+	}
+	if err := walletFacade.transfer("abc", 1234, "xyz", usd("1"), ""); !errors.Is(err, ErrNoExchangeRate) && !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("got %v, want a conversion error", err)
+	}
+	if _, err := parseMoney("1", "XYZ"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Fatalf("got %v, want unknown currency", err)
+	}
+
+	walletFacade.addMoneyToWallet("abc", 1234, usd("1"), "key-1")
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("2"), "key-1"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("got %v, want idempotency key reused", err)
+	}
+
+	var invalid *InvalidSecurityCodeError
+	if err := walletFacade.addMoneyToWallet("abc", 1111, usd("1"), ""); !errors.As(err, &invalid) || invalid.AttemptsLeft != 1 {
+		t.Fatalf("got %v, want 1 attempt left", err)
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1111, usd("1"), ""); !errors.Is(err, ErrInvalidSecurityCode) || !errors.As(err, &invalid) || invalid.AttemptsLeft != 0 {
+		t.Fatalf("got %v, want 0 attempts left", err)
+	}
+	var locked *SecurityCodeLockedError
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("1"), ""); !errors.Is(err, ErrSecurityCodeLocked) || !errors.As(err, &locked) || !locked.Until.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("got %v, want locked until cooldown ends", err)
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	failing := newRetryNotifier(newWebhookNotifier(down.URL, time.Second), 2, 0)
+	failing.sleep = func(time.Duration) {}
+	if err := failing.send(Message{}); !errors.Is(err, ErrNotificationFailed) {
+		t.Fatalf("got %v, want notification failed", err)
+	}
+}
+
+func TestStatement(t *testing.T) {
+	day := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	now := day
+	ledger, _ := newLedger(&memoryStore[LedgerEntry]{}, func() time.Time { return now })
+	walletFacade := newWalletFacadeWithLedger(ledger)
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "USD")
+	for _, step := range []func() error{
+		func() error { return walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "") },
+		func() error { return walletFacade.deductMoneyFromWallet("abc", 1234, usd("3"), "") },
+		func() error { return walletFacade.addMoneyToWallet("abc", 1234, usd("5.50"), "") },
+		func() error { return walletFacade.transfer("abc", 1234, "xyz", usd("2"), "") },
+		func() error { return walletFacade.deductMoneyFromWallet("abc", 1234, usd("1"), "") },
+	} {
+		if err := step(); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+
+	statement, err := walletFacade.statement("abc", 1234, day.Add(24*time.Hour), day.Add(4*24*time.Hour))
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if statement.Opening != usd("10") || statement.Closing != usd("10.50") || len(statement.Lines) != 3 {
+		t.Fatalf("got opening %s closing %s with %d lines", statement.Opening, statement.Closing, len(statement.Lines))
+	}
+	if statement.Totals["debit"] != usd("-3") || statement.Totals["credit"] != usd("5.50") || statement.Totals["transfer"] != usd("-2") {
+		t.Fatalf("got totals %v", statement.Totals)
+	}
+
+	var out strings.Builder
+	if err := statement.export(&out, "csv"); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("csv does not parse: %v", err)
+	}
+	if len(rows) != 9 || rows[1][2] != "opening" || rows[1][4] != "10.00" || rows[2][3] != "-3.00" || rows[8][2] != "closing" || rows[8][4] != "10.50" {
+		t.Fatalf("unexpected csv\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := statement.export(&out, "json"); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var doc struct {
+		Opening      string            `json:"opening_balance"`
+		Closing      string            `json:"closing_balance"`
+		Totals       map[string]string `json:"totals"`
+		Transactions []struct {
+			TxnType string `json:"txn_type"`
+			Amount  string `json:"amount"`
+		} `json:"transactions"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("json does not parse: %v", err)
+	}
+	if doc.Opening != "10.00" || doc.Closing != "10.50" || doc.Totals["credit"] != "5.50" || len(doc.Transactions) != 3 || doc.Transactions[2].Amount != "-2.00" {
+		t.Fatalf("unexpected json\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := statement.export(&out, "text"); err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	for _, want := range []string{"Statement for account abc (USD)", "Period: 2024-01-02 09:00 to 2024-01-05 09:00", "Opening balance", "Closing balance", "transfer"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("text statement misses %q\n%s", want, out.String())
+		}
+	}
+	fmt.Print(out.String())
+
+	if err := statement.export(&out, "pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("got %v, want unknown format", err)
+	}
+	if _, err := walletFacade.statement("nobody", 1234, time.Time{}, time.Time{}); !errors.Is(err, ErrAccountNotFound) {
+		t.Fatalf("got %v, want account not found", err)
+	}
+	if _, err := walletFacade.statement("abc", 1111, time.Time{}, time.Time{}); !errors.Is(err, ErrInvalidSecurityCode) {
+		t.Fatalf("got %v, want invalid security code", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	now := start
+	blocklist := newBlocklistRule("mallory")
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).withRules(func() time.Time { return now },
+		newMaxTransactionRule(usd("100")),
+		RuleSet{newDailyLimitRule(usd("150")), newMonthlyLimitRule(usd("300"))},
+		newVelocityRule(3, 10*time.Minute),
+		blocklist,
+	)
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "USD")
+	walletFacade.createAccount("mallory", 6666, "USD")
+	walletFacade.addMoneyToWallet("abc", 1234, usd("1000"), "")
+	walletFacade.addMoneyToWallet("xyz", 5678, usd("5"), "")
+	walletFacade.addMoneyToWallet("mallory", 6666, usd("5"), "")
+
+	debit := func(at time.Duration, amount string, rule string) {
+		t.Helper()
+		now = start.Add(at)
+		err := walletFacade.deductMoneyFromWallet("abc", 1234, usd(amount), "")
+		var violation *RuleViolationError
+		switch {
+		case rule == "" && err != nil:
+			t.Fatalf("debit of %s at %s: %s", amount, now, err)
+		case rule != "" && (!errors.Is(err, ErrRuleViolation) || !errors.As(err, &violation) || violation.Rule != rule):
+			t.Fatalf("debit of %s at %s got %v, want rejected by %s", amount, now, err, rule)
+		}
+	}
+	day := 24 * time.Hour
+	debit(0, "120", "max transaction")
+	debit(0, "100", "")
+	debit(time.Hour, "60", "daily limit")
+	debit(day, "10", "")
+	debit(day+time.Minute, "10", "")
+	debit(day+2*time.Minute, "10", "")
+	debit(day+3*time.Minute, "10", "velocity")
+	debit(day+12*time.Minute, "10", "")
+	debit(2*day, "100", "")
+	debit(2*day+time.Hour, "50", "")
+	debit(3*day, "20", "monthly limit")
+	debit(31*day, "20", "")
+	if balance, _ := walletFacade.getBalance("abc", 1234); balance != usd("690") {
+		t.Fatalf("balance %s, want 690.00 USD", balance)
+	}
+
+	// debits that fail after the rules ran don't count towards the velocity limit
+	for i := 0; i < 3; i++ {
+		if err := walletFacade.deductMoneyFromWallet("xyz", 5678, usd("50"), ""); !errors.Is(err, ErrInsufficientFunds) {
+			t.Fatalf("got %v, want insufficient funds", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := walletFacade.deductMoneyFromWallet("xyz", 5678, usd("1"), ""); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+
+	if err := walletFacade.transfer("abc", 1234, "mallory", usd("1"), ""); !errors.Is(err, ErrRuleViolation) {
+		t.Fatalf("got %v, want transfer to a blocked account rejected", err)
+	}
+	if err := walletFacade.deductMoneyFromWallet("mallory", 6666, usd("1"), ""); !errors.Is(err, ErrRuleViolation) {
+		t.Fatalf("got %v, want debit from a blocked account rejected", err)
+	}
+	blocklist.unblock("mallory")
+	if err := walletFacade.transfer("abc", 1234, "mallory", usd("1"), ""); err != nil {
+		t.Fatalf("Error after unblocking: %s", err)
+	}
+
+	// a EUR wallet gets the USD limits converted to EUR
+	rates := newStaticRates()
+	rates.set("EUR", "USD", "1.25")
+	walletFacade.withRates(rates)
+	walletFacade.createAccount("eu", 4444, "EUR")
+	walletFacade.addMoneyToWallet("eu", 4444, money("500", "EUR"), "")
+	now = start.Add(40 * day)
+	if err := walletFacade.deductMoneyFromWallet("eu", 4444, money("81", "EUR"), ""); !errors.Is(err, ErrRuleViolation) {
+		t.Fatalf("got %v, want 81 EUR above the 100 USD max transaction", err)
+	}
+	if err := walletFacade.deductMoneyFromWallet("eu", 4444, money("80", "EUR"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if err := walletFacade.deductMoneyFromWallet("eu", 4444, usd("50"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	now = now.Add(time.Hour)
+	if err := walletFacade.deductMoneyFromWallet("eu", 4444, money("1", "EUR"), ""); !errors.Is(err, ErrRuleViolation) {
+		t.Fatalf("got %v, want 121 EUR above the 150 USD daily limit", err)
+	}
+	if balance, _ := walletFacade.getBalance("eu", 4444); balance != money("380", "EUR") {
+		t.Fatalf("balance %s, want 380.00 EUR", balance)
+	}
+}
+
+func TestWalletAPI(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).
+		withLockout(2, time.Minute, func() time.Time { return now }).
+		withRules(nil, newMaxTransactionRule(usd("500")))
+	server := httptest.NewServer(newWalletAPI(walletFacade))
+	defer server.Close()
+
+	call := func(method, path, code, body string, header ...string) (int, map[string]string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if code != "" {
+			req.Header.Set("X-Security-Code", code)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		var got map[string]string
+		json.NewDecoder(resp.Body).Decode(&got)
+		return resp.StatusCode, got
+	}
+	expect := func(wantStatus int, wantBalance string) func(int, map[string]string) {
+		return func(status int, got map[string]string) {
+			t.Helper()
+			if status != wantStatus || (wantBalance != "" && got["balance"] != wantBalance) {
+				t.Fatalf("got %d %v, want %d with balance %q", status, got, wantStatus, wantBalance)
+			}
+		}
+	}
+
+	expect(http.StatusCreated, "0.00")(call("POST", "/accounts", "", `{"account_id":"abc","security_code":1234}`))
+	expect(http.StatusCreated, "0.00")(call("POST", "/accounts", "", `{"account_id":"xyz","security_code":5678,"currency":"USD"}`))
+	expect(http.StatusConflict, "")(call("POST", "/accounts", "", `{"account_id":"abc","security_code":1234}`))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts", "", `{"account":"abc"}`))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts", "", `{"account_id":"nocode"}`))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts", "", `{"account_id":"@fx","security_code":9}`))
+	for _, id := range []string{"a/b", "a\r\nBcc: x@example.com", "..", strings.Repeat("a", 65)} {
+		expect(http.StatusBadRequest, "")(call("POST", "/accounts", "", fmt.Sprintf(`{"account_id":%q,"security_code":9}`, id)))
+	}
+	expect(http.StatusNotFound, "")(call("GET", "/accounts/nocode/balance", "0", ""))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts", "", `{"account_id":"eur","security_code":1,"currency":"XYZ"}`))
+
+	expect(http.StatusOK, "100.00")(call("POST", "/accounts/abc/credit", "1234", `{"amount":"100"}`, "Idempotency-Key", "k1"))
+	expect(http.StatusOK, "100.00")(call("POST", "/accounts/abc/credit", "1234", `{"amount":"100"}`, "Idempotency-Key", "k1"))
+	expect(http.StatusConflict, "")(call("POST", "/accounts/abc/credit", "1234", `{"amount":"5"}`, "Idempotency-Key", "k1"))
+	expect(http.StatusOK, "70.00")(call("POST", "/accounts/abc/debit", "1234", `{"amount":"30"}`))
+	expect(http.StatusUnprocessableEntity, "")(call("POST", "/accounts/abc/debit", "1234", `{"amount":"300"}`))
+	expect(http.StatusForbidden, "")(call("POST", "/accounts/abc/debit", "1234", `{"amount":"600"}`))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts/abc/debit", "1234", `{"amount":"1.234"}`))
+	expect(http.StatusUnprocessableEntity, "")(call("POST", "/accounts/abc/debit", "1234", `{"amount":"1","currency":"EUR"}`))
+	expect(http.StatusOK, "50.00")(call("POST", "/accounts/abc/transfer", "1234", `{"to":"xyz","amount":"20"}`))
+	expect(http.StatusNotFound, "")(call("POST", "/accounts/abc/transfer", "1234", `{"to":"nobody","amount":"1"}`))
+	expect(http.StatusBadRequest, "")(call("POST", "/accounts/abc/transfer", "1234", `{"to":"abc","amount":"1"}`))
+	expect(http.StatusOK, "20.00")(call("GET", "/accounts/xyz/balance", "5678", ""))
+
+	expect(http.StatusNotFound, "")(call("GET", "/accounts/nobody/balance", "1234", ""))
+	expect(http.StatusNotFound, "")(call("GET", "/accounts/abc/unknown", "1234", ""))
+	expect(http.StatusMethodNotAllowed, "")(call("GET", "/accounts/abc/credit", "1234", ""))
+	expect(http.StatusUnauthorized, "")(call("GET", "/accounts/abc/balance", "", ""))
+	expect(http.StatusUnauthorized, "")(call("GET", "/accounts/abc/balance", "1111", ""))
+	expect(http.StatusUnauthorized, "")(call("GET", "/accounts/abc/balance", "1111", ""))
+	req, _ := http.NewRequest("GET", server.URL+"/accounts/abc/balance", nil)
+	req.Header.Set("X-Security-Code", "1234")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET balance: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusLocked || resp.Header.Get("Retry-After") != "60" {
+		t.Fatalf("got %d with Retry-After %q, want 423 after 60s", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	now = now.Add(time.Minute)
+
+	resp, err = http.Get(server.URL + "/accounts/abc/history?format=csv")
+	if err != nil {
+		t.Fatalf("GET history: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("history without a security code got %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest("GET", server.URL+"/accounts/abc/history?format=csv&from=2024-01-01T00:00:00Z", nil)
+	req.Header.Set("X-Security-Code", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET history: %v", err)
+	}
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	resp.Body.Close()
+	if err != nil || resp.Header.Get("Content-Type") != "text/csv; charset=utf-8" || len(rows) != 9 || rows[len(rows)-1][4] != "50.00" {
+		t.Fatalf("got history %v, %v", rows, err)
+	}
+	expect(http.StatusBadRequest, "")(call("GET", "/accounts/abc/history?format=pdf", "1234", ""))
+	expect(http.StatusBadRequest, "")(call("GET", "/accounts/abc/history?from=yesterday", "1234", ""))
+
+	// the money moved before the notification failed, so that is no reason to retry
+	notification, _ := newNotification("{{.TxnType}}", "{{.Amount}}", &failingNotifier{fail: true})
+	walletFacade.withNotification(notification)
+	status, got := call("POST", "/accounts/abc/credit", "1234", `{"amount":"5"}`)
+	expect(http.StatusOK, "55.00")(status, got)
+	if !strings.Contains(got["warning"], ErrNotificationFailed.Error()) {
+		t.Fatalf("got %v, want a warning about the notification", got)
+	}
+}
+
+// failingNotifier refuses every message while fail is set.
+type failingNotifier struct {
+	fail bool
+}
+
+func (f *failingNotifier) send(Message) error {
+	if f.fail {
+		return errors.New("channel down")
+	}
+	return nil
+}
+
+// failingStore is a memoryStore whose writes fail while fail is set.
+type failingStore struct {
+	memoryStore[LedgerEntry]
+	fail bool
+}
+
+func (f *failingStore) append(entries []LedgerEntry) error {
+	if f.fail {
+		return errors.New("disk full")
+	}
+	return f.memoryStore.append(entries)
+}
+
+func TestRollback(t *testing.T) {
+	store := &failingStore{}
+	ledger, _ := newLedger(store, nil)
+	channel := &failingNotifier{}
+	notification, _ := newNotification("{{.TxnType}}", "{{.Amount}}", channel)
+	walletFacade := newWalletFacadeWithLedger(ledger).withNotification(notification)
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "USD")
+	walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "")
+
+	check := func(step string, abc, xyz Money) {
+		t.Helper()
+		for id, want := range map[string]Money{"abc": abc, "xyz": xyz} {
+			acc, _ := walletFacade.lookup(id)
+			if acc.wallet.balance != want || ledger.balance(id, "USD") != want {
+				t.Fatalf("%s: %s wallet %s ledger %s, want %s", step, id, acc.wallet.balance, ledger.balance(id, "USD"), want)
+			}
+		}
+	}
+
+	store.fail = true
+	if err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("4"), ""); err == nil {
+		t.Fatal("debit succeeded without a ledger entry")
+	}
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("4"), ""); err == nil {
+		t.Fatal("credit succeeded without a ledger entry")
+	}
+	if err := walletFacade.transfer("abc", 1234, "xyz", usd("4"), ""); err == nil {
+		t.Fatal("transfer succeeded without a ledger entry")
+	}
+	check("ledger down", usd("10"), usd("0"))
+	store.fail = false
+
+	// a notification goes out after the change is booked, so failing to send it undoes nothing
+	channel.fail = true
+	err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("4"), "debit-1")
+	if !errors.Is(err, ErrNotificationFailed) {
+		t.Fatalf("got %v, want notification failed", err)
+	}
+	check("debit notification down", usd("6"), usd("0"))
+	if again := walletFacade.deductMoneyFromWallet("abc", 1234, usd("4"), "debit-1"); !errors.Is(again, ErrNotificationFailed) {
+		t.Fatalf("retry got %v, want the earlier result", again)
+	}
+	check("debit retried", usd("6"), usd("0"))
+	walletFacade.addMoneyToWallet("abc", 1234, usd("4"), "")
+	check("credit notification down", usd("10"), usd("0"))
+	walletFacade.transfer("abc", 1234, "xyz", usd("4"), "")
+	check("transfer notification down", usd("6"), usd("4"))
+	channel.fail = false
+
+	var types []string
+	for _, e := range ledger.entriesFor("abc", time.Time{}, time.Time{}) {
+		types = append(types, e.TxnType)
+	}
+	want := []string{"credit", "debit", "credit", "transfer"}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Fatalf("got ledger history %v, want %v", types, want)
+	}
+
+	if err := walletFacade.transfer("abc", 1234, "xyz", usd("4"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	check("recovered", usd("2"), usd("8"))
+}
+
+// blockingNotifier holds every message until release is closed.
+type blockingNotifier struct {
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingNotifier) send(Message) error {
+	b.sending <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func TestSlowNotification(t *testing.T) {
+	channel := &blockingNotifier{sending: make(chan struct{}, 2), release: make(chan struct{})}
+	notification, _ := newNotification("{{.TxnType}}", "{{.Amount}}", channel)
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).withNotification(notification)
+	walletFacade.createAccount("abc", 1234, "USD")
+	walletFacade.createAccount("xyz", 5678, "USD")
+
+	done := make(chan error)
+	go func() {
+		done <- walletFacade.addMoneyToWallet("abc", 1234, usd("10"), "")
+	}()
+	<-channel.sending
+	go func() {
+		done <- walletFacade.transfer("abc", 1234, "xyz", usd("4"), "")
+	}()
+	<-channel.sending
+	// both operations are stuck sending, neither may hold an account
+	balances := make(chan Money)
+	go func() {
+		abc, _ := walletFacade.getBalance("abc", 1234)
+		xyz, _ := walletFacade.getBalance("xyz", 5678)
+		balances <- abc
+		balances <- xyz
+	}()
+	select {
+	case abc := <-balances:
+		if xyz := <-balances; abc != usd("6") || xyz != usd("4") {
+			t.Fatalf("got balances %s and %s, want 6.00 USD and 4.00 USD", abc, xyz)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accounts stay locked while notifications are sent")
+	}
+	close(channel.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+}
+
+// startSMTP is a local SMTP stand-in that hands every mail body it receives to mails.
+func startSMTP(t *testing.T) (string, chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 localhost\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case cmd == "DATA":
+						fmt.Fprint(conn, "354 go ahead\r\n")
+						var data strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						mails <- data.String()
+						fmt.Fprint(conn, "250 OK\r\n")
+					case cmd == "QUIT":
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 OK\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestNotification(t *testing.T) {
+	smtpAddr, mails := startSMTP(t)
+
+	var mu sync.Mutex
+	var hooks []Message
+	failures := 2
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var msg Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		hooks = append(hooks, msg)
+	}))
+	defer hook.Close()
+
+	outbox := &Outbox{}
+	webhook := newRetryNotifier(newWebhookNotifier(hook.URL, time.Second), 3, time.Millisecond)
+	var waits []time.Duration
+	webhook.sleep = func(d time.Duration) { waits = append(waits, d) }
+	email := newEmailNotifier(smtpAddr, "wallet@example.com", func(accountID string) string {
+		return accountID + "@example.com"
+	})
+	notification, err := newNotification("Wallet {{.TxnType}}", "{{.Account}} {{.TxnType}} {{.Amount}}, balance {{.Balance}}", outbox, webhook, email)
+	if err != nil {
+		t.Fatalf("newNotification: %v", err)
+	}
+
+	walletFacade := newWalletFacadeWithLedger(newMemoryLedger()).withNotification(notification)
+	walletFacade.createAccount("abc", 1234, "USD")
+	if err := walletFacade.addMoneyToWallet("abc", 1234, usd("10"), ""); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	want := "abc credit 10.00 USD, balance 10.00 USD"
+	if got := outbox.sent(); len(got) != 1 || got[0].Body != want || got[0].Subject != "Wallet credit" {
+		t.Fatalf("outbox got %+v, want body %q", got, want)
+	}
+	if len(hooks) != 1 || hooks[0].Body != want {
+		t.Fatalf("webhook got %+v, want body %q", hooks, want)
+	}
+	if len(waits) != 2 || waits[1] != 2*waits[0] {
+		t.Fatalf("got backoff %v, want two doubling waits", waits)
+	}
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "To: abc@example.com") || !strings.Contains(mail, want) {
+			t.Fatalf("unexpected mail %q", mail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no mail received")
+	}
+
+	mu.Lock()
+	failures = 5
+	mu.Unlock()
+	if err := walletFacade.deductMoneyFromWallet("abc", 1234, usd("1"), ""); err == nil {
+		t.Fatal("undeliverable webhook not reported")
+	}
+	if balance, _ := walletFacade.getBalance("abc", 1234); balance != usd("9") {
+		t.Fatalf("got balance %s, want the debit kept", balance)
+	}
+	want = "abc debit 1.00 USD, balance 9.00 USD"
+	if got := outbox.sent(); len(got) != 2 || got[1].Body != want {
+		t.Fatalf("outbox got %+v, want the other channels still served %q", got, want)
+	}
+
+	if err := email.send(Message{Account: "abc", Subject: "Wallet\r\nBcc: mallory@example.com"}); err == nil {
+		t.Fatal("line break in the subject sent as a header")
+	}
 }
@@ -0,0 +1,114 @@
+package Facade
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sentinel errors of the wallet facade, match them with errors.Is.
+// Where the caller may want details, the returned error is one of the typed
+// errors below, which errors.As can unpack and which still match the sentinel.
+var (
+	ErrAccountNotFound      = errors.New("Account Name is incorrect")
+	ErrAccountExists        = errors.New("Account already exists")
+	ErrAccountReserved      = errors.New("Account id is reserved")
+	ErrInvalidAccountID     = errors.New("Account id may only use letters, digits, '.', '_' and '-'")
+	ErrAccountClosed        = errors.New("Account is closed")
+	ErrBalanceNotZero       = errors.New("Balance must be zero to close the account")
+	ErrSameAccount          = errors.New("Cannot transfer to the same account")
+	ErrInvalidSecurityCode  = errors.New("Security Code is incorrect")
+	ErrSecurityCodeLocked   = errors.New("Security Code is locked")
+	ErrSecurityCodeReused   = errors.New("New Security Code must differ from the old one")
+	ErrInvalidAmount        = errors.New("Invalid amount")
+	ErrInsufficientFunds    = errors.New("Balance is not sufficient")
+	ErrUnknownCurrency      = errors.New("Unknown currency")
+	ErrCurrencyMismatch     = errors.New("Currency mismatch")
+	ErrNoExchangeRate       = errors.New("No exchange rate")
+	ErrIdempotencyKeyReused = errors.New("Idempotency key was used for a different request")
+	ErrNotificationFailed   = errors.New("Notification not delivered")
+	ErrUnbalancedEntry      = errors.New("Ledger transaction does not balance")
+	ErrRollbackFailed       = errors.New("Rollback failed")
+	ErrUnknownFormat        = errors.New("Unknown statement format")
+	ErrRuleViolation        = errors.New("Rejected by spending rules")
+)
+
+type InsufficientFundsError struct {
+	Requested Money
+	Available Money
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%s: requested %s, available %s", ErrInsufficientFunds, e.Requested, e.Available)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+type InvalidSecurityCodeError struct {
+	// AttemptsLeft before the code is locked, 0 means this attempt locked it.
+	AttemptsLeft int
+}
+
+func (e *InvalidSecurityCodeError) Error() string {
+	return fmt.Sprintf("%s: %d attempts left", ErrInvalidSecurityCode, e.AttemptsLeft)
+}
+
+func (e *InvalidSecurityCodeError) Is(target error) bool {
+	return target == ErrInvalidSecurityCode
+}
+
+type SecurityCodeLockedError struct {
+	Until time.Time
+}
+
+func (e *SecurityCodeLockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrSecurityCodeLocked, e.Until.Format(time.RFC3339))
+}
+
+func (e *SecurityCodeLockedError) Is(target error) bool {
+	return target == ErrSecurityCodeLocked
+}
+
+// CurrencyMismatchError: an amount in Have was given where Want was needed.
+type CurrencyMismatchError struct {
+	Have string
+	Want string
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("%s: %s and %s", ErrCurrencyMismatch, e.Have, e.Want)
+}
+
+func (e *CurrencyMismatchError) Is(target error) bool {
+	return target == ErrCurrencyMismatch
+}
+
+// RuleViolationError names the rule that rejected a debit.
+type RuleViolationError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RuleViolationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrRuleViolation, e.Rule, e.Reason)
+}
+
+func (e *RuleViolationError) Is(target error) bool {
+	return target == ErrRuleViolation
+}
+
+// AccountError says which account an error is about.
+type AccountError struct {
+	AccountID string
+	Err       error
+}
+
+func (e *AccountError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.AccountID)
+}
+
+func (e *AccountError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,107 @@
+package Facade
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultIdempotencyRetention = 24 * time.Hour
+
+// idempotency.go: remembers the result of each keyed operation for retention
+//
+// A retry with the same key gets the first call's result without running the
+// operation again. Only results of operations that changed something are
+// kept: success, or an error wrapped in committedError. Any other error means
+// nothing happened, so the key is free again and a retry runs the operation.
+// A retry that arrives while the first call is still running waits for it.
+type idempotencyStore struct {
+	retention time.Duration
+	now       clock
+
+	mu      sync.Mutex
+	results map[string]*idempotentResult
+	order   []string
+}
+
+type idempotentResult struct {
+	request string
+	done    chan struct{}
+	err     error
+	expires time.Time
+}
+
+func newIdempotencyStore(retention time.Duration, now clock) *idempotencyStore {
+	if now == nil {
+		now = time.Now
+	}
+	return &idempotencyStore{
+		retention: retention,
+		now:       now,
+		results:   make(map[string]*idempotentResult),
+	}
+}
+
+// do runs fn once per key. request describes the call, so a key reused for a
+// different operation or amount is rejected instead of returning an unrelated result.
+// An empty key runs fn every time.
+func (s *idempotencyStore) do(key, request string, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+	s.mu.Lock()
+	s.expire()
+	if result, ok := s.results[key]; ok {
+		s.mu.Unlock()
+		if result.request != request {
+			return fmt.Errorf("%w: %q", ErrIdempotencyKeyReused, key)
+		}
+		<-result.done
+		fmt.Println("Returning result of earlier request with the same idempotency key")
+		return result.err
+	}
+	result := &idempotentResult{request: request, done: make(chan struct{})}
+	s.results[key] = result
+	s.mu.Unlock()
+
+	result.err = fn()
+
+	s.mu.Lock()
+	var committed *committedError
+	if result.err == nil || errors.As(result.err, &committed) {
+		result.expires = s.now().Add(s.retention)
+		s.order = append(s.order, key)
+	} else {
+		delete(s.results, key)
+	}
+	s.mu.Unlock()
+	close(result.done)
+	return result.err
+}
+
+// committedError is returned by an operation that failed after its changes
+// were made final, a retry must not apply them a second time.
+type committedError struct {
+	err error
+}
+
+func (e *committedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *committedError) Unwrap() error {
+	return e.err
+}
+
+// expire drops finished results older than retention, mu must be held.
+// Keys are appended to order as they finish, so expiry times only go up along it.
+func (s *idempotencyStore) expire() {
+	now := s.now()
+	i := 0
+	for i < len(s.order) && !now.Before(s.results[s.order[i]].expires) {
+		delete(s.results, s.order[i])
+		i++
+	}
+	s.order = s.order[i:]
+}
@@ -0,0 +1,324 @@
+package Facade
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// clock is injected where the facade needs the time, so tests can fix it.
+type clock func() time.Time
+
+// externalAccount is the other side of money entering or leaving the wallets,
+// fxAccount the other side of both legs of a currency exchange. Ids with
+// internalPrefix are kept for such accounts, no wallet can be opened on them.
+const (
+	internalPrefix  = "@"
+	externalAccount = internalPrefix + "external"
+	fxAccount       = internalPrefix + "fx"
+)
+
+// LedgerEntry is one leg of a transaction. Entries are never changed once written,
+// the ledger only hands out copies.
+type LedgerEntry struct {
+	Seq      int64     `json:"seq"`
+	TxnID    string    `json:"txn_id"`
+	TxnType  string    `json:"txn_type"`
+	Account  string    `json:"account"`
+	Currency string    `json:"currency"`
+	Amount   int64     `json:"amount"`
+	Balance  int64     `json:"balance"`
+	Time     time.Time `json:"time"`
+}
+
+// money returns the entry's amount and the balance after it.
+func (e LedgerEntry) money() (Money, Money) {
+	return Money{amount: e.Amount, currency: e.Currency}, Money{amount: e.Balance, currency: e.Currency}
+}
+
+// posting moves amount into account, a negative amount moves it out.
+type posting struct {
+	account string
+	amount  Money
+}
+
+// balanceKey: accounts like externalAccount hold several currencies.
+type balanceKey struct {
+	account  string
+	currency string
+}
+
+// ledgerStore keeps entries somewhere that outlives the process.
+type ledgerStore interface {
+	load() ([]LedgerEntry, error)
+	append(entries []LedgerEntry) error
+}
+
+// ledger.go: Complex subsystem parts
+//
+// A double-entry ledger: every transaction is a set of postings that sum to zero
+// in each currency, so money is never created, only moved between accounts
+// (or from externalAccount).
+type Ledger struct {
+	store ledgerStore
+	now   clock
+
+	mu       sync.Mutex
+	entries  []LedgerEntry
+	balances map[balanceKey]int64
+	nextSeq  int64
+}
+
+func newLedger(store ledgerStore, now clock) (*Ledger, error) {
+	if now == nil {
+		now = time.Now
+	}
+	entries, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	l := &Ledger{
+		store:    store,
+		now:      now,
+		balances: make(map[balanceKey]int64),
+		nextSeq:  1,
+	}
+	for _, e := range entries {
+		l.apply(e)
+	}
+	return l, nil
+}
+
+func newMemoryLedger() *Ledger {
+	l, _ := newLedger(&memoryStore[LedgerEntry]{}, nil)
+	return l
+}
+
+// newFileLedger opens (or creates) a JSON lines ledger at path and replays it.
+func newFileLedger(path string) (*Ledger, error) {
+	store, err := openFileStore[LedgerEntry](path)
+	if err != nil {
+		return nil, err
+	}
+	return newLedger(store, nil)
+}
+
+// makeEntry returns the id of the transaction it wrote.
+func (l *Ledger) makeEntry(accountID, txnType string, amount Money) (string, error) {
+	fmt.Printf("Make ledger entry for accountId %s with txnType %s for amount %s\n", accountID, txnType, amount)
+	switch txnType {
+	case "credit":
+		return l.post(txnType, posting{externalAccount, amount.neg()}, posting{accountID, amount})
+	case "debit":
+		return l.post(txnType, posting{accountID, amount.neg()}, posting{externalAccount, amount})
+	}
+	return "", fmt.Errorf("unknown txnType %s", txnType)
+}
+
+// makeTransferEntry records sent leaving fromID and received arriving at toID.
+// They differ when the wallets hold different currencies, fxAccount then takes
+// the sent amount and pays out the received one.
+func (l *Ledger) makeTransferEntry(fromID, toID string, sent, received Money) (string, error) {
+	fmt.Printf("Make ledger entry for transfer from %s to %s for amount %s\n", fromID, toID, sent)
+	if sent.currency == received.currency {
+		return l.post("transfer", posting{fromID, sent.neg()}, posting{toID, received})
+	}
+	return l.post("transfer",
+		posting{fromID, sent.neg()},
+		posting{fxAccount, sent},
+		posting{fxAccount, received.neg()},
+		posting{toID, received},
+	)
+}
+
+// post writes one balanced transaction and returns its id.
+func (l *Ledger) post(txnType string, postings ...posting) (string, error) {
+	sums := make(map[string]Money)
+	for _, p := range postings {
+		sum, ok := sums[p.amount.currency]
+		if !ok {
+			sum.currency = p.amount.currency
+		}
+		sum, err := sum.add(p.amount)
+		if err != nil {
+			return "", err
+		}
+		sums[p.amount.currency] = sum
+	}
+	for _, sum := range sums {
+		if sum.amount != 0 {
+			return "", fmt.Errorf("%w: off by %s", ErrUnbalancedEntry, sum)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	txnID := fmt.Sprintf("txn-%d", l.nextSeq)
+	now := l.now()
+	entries := make([]LedgerEntry, 0, len(postings))
+	balances := make(map[balanceKey]Money)
+	for i, p := range postings {
+		key := balanceKey{p.account, p.amount.currency}
+		balance, ok := balances[key]
+		if !ok {
+			balance = Money{amount: l.balances[key], currency: key.currency}
+		}
+		balance, err := balance.add(p.amount)
+		if err != nil {
+			return "", err
+		}
+		balances[key] = balance
+		entries = append(entries, LedgerEntry{
+			Seq:      l.nextSeq + int64(i),
+			TxnID:    txnID,
+			TxnType:  txnType,
+			Account:  p.account,
+			Currency: p.amount.currency,
+			Amount:   p.amount.amount,
+			Balance:  balance.amount,
+			Time:     now,
+		})
+	}
+	if err := l.store.append(entries); err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		l.apply(e)
+	}
+	return txnID, nil
+}
+
+// apply must be called with mu held, or before the ledger is shared.
+func (l *Ledger) apply(e LedgerEntry) {
+	l.entries = append(l.entries, e)
+	l.balances[balanceKey{e.Account, e.Currency}] = e.Balance
+	l.nextSeq = e.Seq + 1
+}
+
+func (l *Ledger) balance(account, currency string) Money {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Money{amount: l.balances[balanceKey{account, currency}], currency: currency}
+}
+
+// has tells whether account was ever posted to, in any currency.
+func (l *Ledger) has(account string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.balances {
+		if key.account == account {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Ledger) Close() error {
+	if closer, ok := l.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// entriesFor returns the account's entries with from <= Time < to.
+// A zero from or to leaves that side open.
+func (l *Ledger) entriesFor(account string, from, to time.Time) []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var result []LedgerEntry
+	for _, e := range l.entries {
+		if e.Account != account {
+			continue
+		}
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !e.Time.Before(to) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// memoryStore.go: store that forgets everything on restart
+type memoryStore[T any] struct {
+	records []T
+}
+
+func (m *memoryStore[T]) load() ([]T, error) {
+	return append([]T(nil), m.records...), nil
+}
+
+func (m *memoryStore[T]) append(records []T) error {
+	m.records = append(m.records, records...)
+	return nil
+}
+
+// fileStore.go: append-only JSON lines file, one record per line
+type fileStore[T any] struct {
+	file *os.File
+}
+
+func openFileStore[T any](path string) (*fileStore[T], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore[T]{file: file}, nil
+}
+
+// load reads every complete line. A partial last line, left by a crash in the
+// middle of a write, is cut off so the next append starts on a clean line.
+func (f *fileStore[T]) load() ([]T, error) {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var records []T
+	reader := bufio.NewReader(f.file)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := f.file.Truncate(good); err != nil {
+					return nil, err
+				}
+			}
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record T
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", f.file.Name(), len(records)+1, err)
+		}
+		records = append(records, record)
+		good += int64(len(line))
+	}
+}
+
+func (f *fileStore[T]) append(records []T) error {
+	var buf []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if _, err := f.file.Write(buf); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *fileStore[T]) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,169 @@
+package Facade
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const defaultCurrency = "USD"
+
+// currencyDigits is the number of minor unit digits of each supported currency.
+var currencyDigits = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CNY": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+// money.go: an exact amount counted in the currency's minor unit, e.g. cents
+type Money struct {
+	amount   int64
+	currency string
+}
+
+func newMoney(amount int64, currency string) (Money, error) {
+	if _, ok := currencyDigits[currency]; !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	return Money{amount: amount, currency: currency}, nil
+}
+
+// parseMoney reads a decimal like "12.34". More fraction digits than the
+// currency has is an error rather than a silent rounding.
+func parseMoney(value, currency string) (Money, error) {
+	digits, ok := currencyDigits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, value)
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(digits)))
+	if !r.IsInt() {
+		return Money{}, fmt.Errorf("%w %q: more than %d decimals for %s", ErrInvalidAmount, value, digits, currency)
+	}
+	if !r.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%w %q: too large", ErrInvalidAmount, value)
+	}
+	return Money{amount: r.Num().Int64(), currency: currency}, nil
+}
+
+func (m Money) String() string {
+	return m.decimal() + " " + m.currency
+}
+
+// decimal is the amount without the currency, e.g. "-12.30".
+func (m Money) decimal() string {
+	digits := currencyDigits[m.currency]
+	sign := ""
+	amount := m.amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := pow10(digits).Int64()
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, digits, amount%unit)
+}
+
+func (m Money) isPositive() bool {
+	return m.amount > 0
+}
+
+func (m Money) add(other Money) (Money, error) {
+	if m.currency != other.currency {
+		return Money{}, &CurrencyMismatchError{Have: other.currency, Want: m.currency}
+	}
+	sum := m.amount + other.amount
+	if (other.amount > 0 && sum < m.amount) || (other.amount < 0 && sum > m.amount) {
+		return Money{}, fmt.Errorf("%w: %s plus %s is out of range", ErrInvalidAmount, m, other)
+	}
+	return Money{amount: sum, currency: m.currency}, nil
+}
+
+func (m Money) sub(other Money) (Money, error) {
+	if other.amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %s minus %s is out of range", ErrInvalidAmount, m, other)
+	}
+	return m.add(other.neg())
+}
+
+func (m Money) neg() Money {
+	return Money{amount: -m.amount, currency: m.currency}
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// RateProvider quotes how much of currency to one unit of currency from buys.
+type RateProvider interface {
+	rate(from, to string) (*big.Rat, error)
+}
+
+// staticRates.go: a fixed rate table, inverse rates are derived when missing
+type StaticRates struct {
+	rates map[[2]string]*big.Rat
+}
+
+func newStaticRates() *StaticRates {
+	return &StaticRates{
+		rates: make(map[[2]string]*big.Rat),
+	}
+}
+
+func (s *StaticRates) set(from, to, rate string) error {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return fmt.Errorf("Invalid rate %q", rate)
+	}
+	s.rates[[2]string{from, to}] = r
+	return nil
+}
+
+func (s *StaticRates) rate(from, to string) (*big.Rat, error) {
+	if r, ok := s.rates[[2]string{from, to}]; ok {
+		return r, nil
+	}
+	if r, ok := s.rates[[2]string{to, from}]; ok {
+		return new(big.Rat).Inv(r), nil
+	}
+	return nil, fmt.Errorf("%w from %s to %s", ErrNoExchangeRate, from, to)
+}
+
+// convert changes m into currency to, rounding half away from zero to the minor unit.
+func convert(m Money, to string, rates RateProvider) (Money, error) {
+	if m.currency == to {
+		return m, nil
+	}
+	toDigits, ok := currencyDigits[to]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, to)
+	}
+	if rates == nil {
+		return Money{}, &CurrencyMismatchError{Have: m.currency, Want: to}
+	}
+	r, err := rates.rate(m.currency, to)
+	if err != nil {
+		return Money{}, err
+	}
+	v := new(big.Rat).SetInt64(m.amount)
+	v.Mul(v, r)
+	v.Mul(v, new(big.Rat).SetFrac(pow10(toDigits), pow10(currencyDigits[m.currency])))
+
+	q, rem := new(big.Int).QuoRem(v.Num(), v.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(v.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(v.Sign())))
+	}
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("%w: converted amount is too large", ErrInvalidAmount)
+	}
+	return Money{amount: q.Int64(), currency: to}, nil
+}
@@ -0,0 +1,221 @@
+package Facade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// walletEvent is what the notification templates are rendered with.
+type walletEvent struct {
+	Account string
+	TxnType string
+	Amount  Money
+	Balance Money
+}
+
+// Message is a rendered notification.
+type Message struct {
+	Account string `json:"account"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers a message over one channel.
+type Notifier interface {
+	send(msg Message) error
+}
+
+const (
+	defaultSubjectTemplate = "Wallet {{.TxnType}}"
+	defaultBodyTemplate    = "Account {{.Account}}: {{.TxnType}} of {{.Amount}}, new balance {{.Balance}}"
+)
+
+// notification.go: Complex subsystem parts
+// A zero Notification only prints, channels are added with newNotification.
+type Notification struct {
+	subject  *template.Template
+	body     *template.Template
+	channels []Notifier
+}
+
+func newNotification(subjectTemplate, bodyTemplate string, channels ...Notifier) (*Notification, error) {
+	subject, err := template.New("subject").Parse(subjectTemplate)
+	if err != nil {
+		return nil, err
+	}
+	body, err := template.New("body").Parse(bodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &Notification{
+		subject:  subject,
+		body:     body,
+		channels: channels,
+	}, nil
+}
+
+func newDefaultNotification(channels ...Notifier) *Notification {
+	n, _ := newNotification(defaultSubjectTemplate, defaultBodyTemplate, channels...)
+	return n
+}
+
+func (n *Notification) sendWalletCreditNotification(event walletEvent) error {
+	fmt.Println("Sending wallet credit notification")
+	return n.dispatch(event)
+}
+
+func (n *Notification) sendWalletDebitNotification(event walletEvent) error {
+	fmt.Println("Sending wallet debit notification")
+	return n.dispatch(event)
+}
+
+// dispatch sends to every channel, one failing channel doesn't stop the others.
+func (n *Notification) dispatch(event walletEvent) error {
+	if len(n.channels) == 0 {
+		return nil
+	}
+	msg, err := n.render(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, channel := range n.channels {
+		if err := channel.send(msg); err != nil {
+			if !errors.Is(err, ErrNotificationFailed) {
+				err = fmt.Errorf("%w: %w", ErrNotificationFailed, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notification) render(event walletEvent) (Message, error) {
+	var subject, body strings.Builder
+	if err := n.subject.Execute(&subject, event); err != nil {
+		return Message{}, err
+	}
+	if err := n.body.Execute(&body, event); err != nil {
+		return Message{}, err
+	}
+	return Message{Account: event.Account, Subject: subject.String(), Body: body.String()}, nil
+}
+
+// outbox.go: keeps messages in memory, for tests and for a worker to pick up later
+type Outbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *Outbox) send(msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+func (o *Outbox) sent() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// emailNotifier.go: sends plain text mail through an SMTP server
+type EmailNotifier struct {
+	addr      string
+	from      string
+	addressOf func(accountID string) string
+}
+
+// newEmailNotifier sends through the SMTP server at addr. addressOf maps an
+// account to its mail address, "" skips the account.
+func newEmailNotifier(addr, from string, addressOf func(accountID string) string) *EmailNotifier {
+	return &EmailNotifier{
+		addr:      addr,
+		from:      from,
+		addressOf: addressOf,
+	}
+}
+
+func (e *EmailNotifier) send(msg Message) error {
+	to := e.addressOf(msg.Account)
+	if to == "" {
+		return nil
+	}
+	// a line break would let a template or an address add headers of its own
+	for _, header := range []string{e.from, to, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return fmt.Errorf("line break in mail header %q", header)
+		}
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", e.from, to, msg.Subject, msg.Body)
+	return smtp.SendMail(e.addr, nil, e.from, []string{to}, []byte(body))
+}
+
+// webhookNotifier.go: POSTs the message as JSON, any non 2xx answer is a failure
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *WebhookNotifier) send(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// retryNotifier.go: retries a channel, waiting backoff and doubling it after each failure
+type RetryNotifier struct {
+	next     Notifier
+	attempts int
+	backoff  time.Duration
+	sleep    func(time.Duration)
+}
+
+func newRetryNotifier(next Notifier, attempts int, backoff time.Duration) *RetryNotifier {
+	return &RetryNotifier{
+		next:     next,
+		attempts: attempts,
+		backoff:  backoff,
+		sleep:    time.Sleep,
+	}
+}
+
+func (r *RetryNotifier) send(msg Message) error {
+	err := r.next.send(msg)
+	wait := r.backoff
+	for attempt := 1; attempt < r.attempts && err != nil; attempt++ {
+		r.sleep(wait)
+		wait *= 2
+		err = r.next.send(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("%w after %d attempts: %w", ErrNotificationFailed, r.attempts, err)
+	}
+	return nil
+}
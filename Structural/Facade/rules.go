@@ -0,0 +1,262 @@
+package Facade
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// debitAttempt is what a rule gets to see of a debit: the account money
+// leaves, the receiving account for a transfer, and the amount in the
+// wallet's currency.
+type debitAttempt struct {
+	Account string
+	To      string
+	Amount  Money
+	Time    time.Time
+}
+
+// Rule decides whether a debit may go ahead, given the account's earlier
+// debits oldest first. It returns a *RuleViolationError to reject it.
+// A limit in another currency is converted to the wallet's with rates.
+// lookback is how far back the rule needs that history.
+type Rule interface {
+	check(attempt debitAttempt, history []debitAttempt, rates RateProvider) error
+	lookback() time.Duration
+}
+
+// ruleSet.go: composes rules, the first one to reject wins
+type RuleSet []Rule
+
+func (rs RuleSet) check(attempt debitAttempt, history []debitAttempt, rates RateProvider) error {
+	for _, rule := range rs {
+		if err := rule.check(attempt, history, rates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs RuleSet) lookback() time.Duration {
+	var longest time.Duration
+	for _, rule := range rs {
+		longest = max(longest, rule.lookback())
+	}
+	return longest
+}
+
+// rulesEngine.go: runs the rules before a debit and remembers the debits it let through
+type RulesEngine struct {
+	rules RuleSet
+	now   clock
+
+	mu      sync.Mutex
+	history map[string][]debitAttempt
+}
+
+func newRulesEngine(now clock, rules ...Rule) *RulesEngine {
+	if now == nil {
+		now = time.Now
+	}
+	return &RulesEngine{
+		rules:   rules,
+		now:     now,
+		history: make(map[string][]debitAttempt),
+	}
+}
+
+// admit checks a debit against the rules and, if none rejects it, counts it
+// towards the account's history. Call forget if the debit is rolled back.
+func (e *RulesEngine) admit(account, to string, amount Money, rates RateProvider) (debitAttempt, error) {
+	attempt := debitAttempt{Account: account, To: to, Amount: amount, Time: e.now()}
+	if len(e.rules) == 0 {
+		return attempt, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	history := e.history[account]
+	cutoff := attempt.Time.Add(-e.rules.lookback())
+	i := 0
+	for i < len(history) && history[i].Time.Before(cutoff) {
+		i++
+	}
+	history = history[i:]
+	e.history[account] = history
+	if err := e.rules.check(attempt, history, rates); err != nil {
+		return attempt, err
+	}
+	e.history[account] = append(history, attempt)
+	fmt.Println("Spending rules passed")
+	return attempt, nil
+}
+
+func (e *RulesEngine) forget(attempt debitAttempt) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	history := e.history[attempt.Account]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i] == attempt {
+			e.history[attempt.Account] = append(history[:i:i], history[i+1:]...)
+			return
+		}
+	}
+}
+
+// maxTransaction.go: caps a single debit
+type MaxTransactionRule struct {
+	limit Money
+}
+
+func newMaxTransactionRule(limit Money) *MaxTransactionRule {
+	return &MaxTransactionRule{limit: limit}
+}
+
+func (r *MaxTransactionRule) check(attempt debitAttempt, _ []debitAttempt, rates RateProvider) error {
+	limit, err := convert(r.limit, attempt.Amount.currency, rates)
+	if err != nil {
+		return err
+	}
+	over, err := attempt.Amount.sub(limit)
+	if err != nil {
+		return err
+	}
+	if over.isPositive() {
+		return &RuleViolationError{"max transaction", fmt.Sprintf("%s is above the limit of %s", attempt.Amount, limit)}
+	}
+	return nil
+}
+
+func (r *MaxTransactionRule) lookback() time.Duration {
+	return 0
+}
+
+// periodLimit.go: caps the sum of debits in the current calendar day or month
+type PeriodLimitRule struct {
+	name   string
+	limit  Money
+	start  func(time.Time) time.Time
+	period time.Duration
+}
+
+func newDailyLimitRule(limit Money) *PeriodLimitRule {
+	return &PeriodLimitRule{
+		name:  "daily limit",
+		limit: limit,
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		},
+		period: 25 * time.Hour,
+	}
+}
+
+func newMonthlyLimitRule(limit Money) *PeriodLimitRule {
+	return &PeriodLimitRule{
+		name:  "monthly limit",
+		limit: limit,
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		},
+		period: 32 * 24 * time.Hour,
+	}
+}
+
+// check sums history in the wallet's currency, all of an account's debits are in it.
+func (r *PeriodLimitRule) check(attempt debitAttempt, history []debitAttempt, rates RateProvider) error {
+	limit, err := convert(r.limit, attempt.Amount.currency, rates)
+	if err != nil {
+		return err
+	}
+	start := r.start(attempt.Time)
+	spent := attempt.Amount
+	for _, earlier := range history {
+		if earlier.Time.Before(start) {
+			continue
+		}
+		spent, err = spent.add(earlier.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	over, err := spent.sub(limit)
+	if err != nil {
+		return err
+	}
+	if over.isPositive() {
+		return &RuleViolationError{r.name, fmt.Sprintf("%s would be spent, the limit is %s", spent, limit)}
+	}
+	return nil
+}
+
+// lookback covers the longest period plus a daylight saving shift.
+func (r *PeriodLimitRule) lookback() time.Duration {
+	return r.period
+}
+
+// velocity.go: at most count debits within any window
+type VelocityRule struct {
+	count  int
+	window time.Duration
+}
+
+func newVelocityRule(count int, window time.Duration) *VelocityRule {
+	return &VelocityRule{count: count, window: window}
+}
+
+func (r *VelocityRule) check(attempt debitAttempt, history []debitAttempt, _ RateProvider) error {
+	since := attempt.Time.Add(-r.window)
+	recent := 0
+	for _, earlier := range history {
+		if earlier.Time.After(since) {
+			recent++
+		}
+	}
+	if recent >= r.count {
+		return &RuleViolationError{"velocity", fmt.Sprintf("more than %d debits in %s", r.count, r.window)}
+	}
+	return nil
+}
+
+func (r *VelocityRule) lookback() time.Duration {
+	return r.window
+}
+
+// blocklist.go: rejects debits from or transfers to blocked accounts
+type BlocklistRule struct {
+	mu      sync.RWMutex
+	blocked map[string]bool
+}
+
+func newBlocklistRule(accounts ...string) *BlocklistRule {
+	r := &BlocklistRule{blocked: make(map[string]bool)}
+	for _, account := range accounts {
+		r.block(account)
+	}
+	return r
+}
+
+func (r *BlocklistRule) block(account string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocked[account] = true
+}
+
+func (r *BlocklistRule) unblock(account string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.blocked, account)
+}
+
+func (r *BlocklistRule) check(attempt debitAttempt, _ []debitAttempt, _ RateProvider) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, account := range []string{attempt.Account, attempt.To} {
+		if account != "" && r.blocked[account] {
+			return &RuleViolationError{"blocklist", fmt.Sprintf("account %s is blocked", account)}
+		}
+	}
+	return nil
+}
+
+func (r *BlocklistRule) lookback() time.Duration {
+	return 0
+}
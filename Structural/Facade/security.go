@@ -0,0 +1,139 @@
+package Facade
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// hashRounds slows down guessing a PIN from a leaked hash. A real service
+// would use bcrypt or argon2, this keeps the example on the standard library.
+const hashRounds = 1000
+
+// lockoutPolicy: after maxAttempts wrong codes in a row the code is locked for cooldown.
+type lockoutPolicy struct {
+	maxAttempts int
+	cooldown    time.Duration
+	now         clock
+}
+
+var defaultLockout = lockoutPolicy{
+	maxAttempts: 3,
+	cooldown:    15 * time.Minute,
+	now:         time.Now,
+}
+
+// SecurityEvent is one entry of a security code's audit trail.
+type SecurityEvent struct {
+	Time  time.Time
+	Event string
+}
+
+const (
+	eventFailed         = "failed"
+	eventLocked         = "locked"
+	eventRejectedLocked = "rejected while locked"
+	eventRotated        = "rotated"
+)
+
+// securityCode.go: Complex subsystem parts
+// Only a salted hash of the PIN is kept.
+type SecurityCode struct {
+	salt   []byte
+	hash   []byte
+	policy lockoutPolicy
+
+	failures    int
+	lockedUntil time.Time
+	audit       []SecurityEvent
+}
+
+func newSecurityCode(code int) *SecurityCode {
+	return newSecurityCodeWithPolicy(code, defaultLockout)
+}
+
+func newSecurityCodeWithPolicy(code int, policy lockoutPolicy) *SecurityCode {
+	s := restoreSecurityCode(nil, nil, policy)
+	s.set(code)
+	return s
+}
+
+// restoreSecurityCode takes a salt and hash saved earlier, failed attempts start over.
+func restoreSecurityCode(salt, hash []byte, policy lockoutPolicy) *SecurityCode {
+	if policy.now == nil {
+		policy.now = time.Now
+	}
+	return &SecurityCode{salt: salt, hash: hash, policy: policy}
+}
+
+func (s *SecurityCode) checkCode(incomingCode int) error {
+	now := s.policy.now()
+	if now.Before(s.lockedUntil) {
+		s.record(now, eventRejectedLocked)
+		return &SecurityCodeLockedError{Until: s.lockedUntil}
+	}
+	if subtle.ConstantTimeCompare(hashCode(s.salt, incomingCode), s.hash) != 1 {
+		s.failures++
+		s.record(now, eventFailed)
+		attemptsLeft := s.policy.maxAttempts - s.failures
+		if attemptsLeft <= 0 {
+			attemptsLeft = 0
+			s.failures = 0
+			s.lockedUntil = now.Add(s.policy.cooldown)
+			s.record(now, eventLocked)
+		}
+		return &InvalidSecurityCodeError{AttemptsLeft: attemptsLeft}
+	}
+	s.failures = 0
+	fmt.Println("SecurityCode Verified")
+	return nil
+}
+
+// rotateCode replaces the code after checking the old one. A wrong old code
+// counts towards the lockout like any other failed check.
+func (s *SecurityCode) rotateCode(oldCode, newCode int) error {
+	err := s.checkCode(oldCode)
+	if err != nil {
+		return err
+	}
+	if oldCode == newCode {
+		return ErrSecurityCodeReused
+	}
+	s.set(newCode)
+	s.record(s.policy.now(), eventRotated)
+	fmt.Println("SecurityCode rotated")
+	return nil
+}
+
+func (s *SecurityCode) auditTrail() []SecurityEvent {
+	return append([]SecurityEvent(nil), s.audit...)
+}
+
+func (s *SecurityCode) set(code int) {
+	s.salt = make([]byte, 16)
+	if _, err := rand.Read(s.salt); err != nil {
+		panic(err)
+	}
+	s.hash = hashCode(s.salt, code)
+}
+
+func (s *SecurityCode) record(now time.Time, event string) {
+	s.audit = append(s.audit, SecurityEvent{Time: now, Event: event})
+}
+
+func hashCode(salt []byte, code int) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(strconv.Itoa(code)))
+	sum := h.Sum(nil)
+	for i := 1; i < hashRounds; i++ {
+		h.Reset()
+		h.Write(sum)
+		h.Write(salt)
+		sum = h.Sum(sum[:0])
+	}
+	return sum
+}
@@ -0,0 +1,198 @@
+package Facade
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+const statementTimeFormat = "2006-01-02 15:04"
+
+// statement.go: an account's transactions over a period, read from the ledger
+//
+// The period is From <= Time < To, a zero From or To leaves that side open.
+// Totals sums the amounts of each transaction type, so Opening plus all
+// Totals is Closing.
+type Statement struct {
+	Account  string
+	Currency string
+	From     time.Time
+	To       time.Time
+	Opening  Money
+	Closing  Money
+	Lines    []StatementLine
+	Totals   map[string]Money
+}
+
+type StatementLine struct {
+	Time    time.Time
+	TxnID   string
+	TxnType string
+	Amount  Money
+	Balance Money
+}
+
+func newStatement(ledger *Ledger, account, currency string, from, to time.Time) *Statement {
+	s := &Statement{
+		Account:  account,
+		Currency: currency,
+		From:     from,
+		To:       to,
+		Opening:  Money{currency: currency},
+		Totals:   make(map[string]Money),
+	}
+	for _, e := range ledger.entriesFor(account, time.Time{}, to) {
+		if e.Currency != currency {
+			continue
+		}
+		amount, balance := e.money()
+		if !from.IsZero() && e.Time.Before(from) {
+			s.Opening = balance
+			continue
+		}
+		s.Lines = append(s.Lines, StatementLine{e.Time, e.TxnID, e.TxnType, amount, balance})
+		total := s.Totals[e.TxnType]
+		total.currency = currency
+		s.Totals[e.TxnType], _ = total.add(amount)
+	}
+	s.Closing = s.Opening
+	if len(s.Lines) > 0 {
+		s.Closing = s.Lines[len(s.Lines)-1].Balance
+	}
+	return s
+}
+
+// txnTypes returns the keys of Totals in a stable order.
+func (s *Statement) txnTypes() []string {
+	types := make([]string, 0, len(s.Totals))
+	for txnType := range s.Totals {
+		types = append(types, txnType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// export writes the statement as "csv", "json" or "text".
+func (s *Statement) export(out io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return s.writeCSV(out)
+	case "json":
+		return s.writeJSON(out)
+	case "text":
+		return s.writeText(out)
+	}
+	return fmt.Errorf("%w %q", ErrUnknownFormat, format)
+}
+
+// writeCSV writes one row per transaction between an opening and a closing row.
+// Totals rows come before the closing row, with the transaction type in the type column.
+func (s *Statement) writeCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"time", "txn_id", "type", "amount", "balance", "currency"})
+	w.Write([]string{formatTime(s.From), "", "opening", "", s.Opening.decimal(), s.Currency})
+	for _, line := range s.Lines {
+		w.Write([]string{
+			formatTime(line.Time), line.TxnID, line.TxnType,
+			line.Amount.decimal(), line.Balance.decimal(), s.Currency,
+		})
+	}
+	for _, txnType := range s.txnTypes() {
+		w.Write([]string{"", "", "total " + txnType, s.Totals[txnType].decimal(), "", s.Currency})
+	}
+	w.Write([]string{formatTime(s.To), "", "closing", "", s.Closing.decimal(), s.Currency})
+	w.Flush()
+	return w.Error()
+}
+
+type statementJSON struct {
+	Account      string              `json:"account"`
+	Currency     string              `json:"currency"`
+	From         *time.Time          `json:"from,omitempty"`
+	To           *time.Time          `json:"to,omitempty"`
+	Opening      string              `json:"opening_balance"`
+	Closing      string              `json:"closing_balance"`
+	Totals       map[string]string   `json:"totals"`
+	Transactions []statementLineJSON `json:"transactions"`
+}
+
+type statementLineJSON struct {
+	Time    time.Time `json:"time"`
+	TxnID   string    `json:"txn_id"`
+	TxnType string    `json:"txn_type"`
+	Amount  string    `json:"amount"`
+	Balance string    `json:"balance"`
+}
+
+// writeJSON writes amounts as decimal strings, so no precision is lost to floats.
+func (s *Statement) writeJSON(out io.Writer) error {
+	doc := statementJSON{
+		Account:      s.Account,
+		Currency:     s.Currency,
+		Opening:      s.Opening.decimal(),
+		Closing:      s.Closing.decimal(),
+		Totals:       make(map[string]string),
+		Transactions: []statementLineJSON{},
+	}
+	if !s.From.IsZero() {
+		doc.From = &s.From
+	}
+	if !s.To.IsZero() {
+		doc.To = &s.To
+	}
+	for txnType, total := range s.Totals {
+		doc.Totals[txnType] = total.decimal()
+	}
+	for _, line := range s.Lines {
+		doc.Transactions = append(doc.Transactions, statementLineJSON{
+			line.Time, line.TxnID, line.TxnType, line.Amount.decimal(), line.Balance.decimal(),
+		})
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// writeText writes a printable statement with aligned columns.
+func (s *Statement) writeText(out io.Writer) error {
+	from, to := "beginning", "now"
+	if !s.From.IsZero() {
+		from = s.From.UTC().Format(statementTimeFormat)
+	}
+	if !s.To.IsZero() {
+		to = s.To.UTC().Format(statementTimeFormat)
+	}
+	fmt.Fprintf(out, "Statement for account %s (%s)\n", s.Account, s.Currency)
+	fmt.Fprintf(out, "Period: %s to %s\n\n", from, to)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Date\tTransaction\tType\tAmount\tBalance\t\n")
+	fmt.Fprintf(w, "\t\tOpening balance\t\t%s\t\n", s.Opening.decimal())
+	for _, line := range s.Lines {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n",
+			line.Time.UTC().Format(statementTimeFormat), line.TxnID, line.TxnType,
+			line.Amount.decimal(), line.Balance.decimal())
+	}
+	fmt.Fprintf(w, "\t\tClosing balance\t\t%s\t\n", s.Closing.decimal())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nTotals\n")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, txnType := range s.txnTypes() {
+		fmt.Fprintf(w, "%s\t%s\t\n", txnType, s.Totals[txnType].decimal())
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
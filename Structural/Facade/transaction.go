@@ -0,0 +1,44 @@
+package Facade
+
+import (
+	"errors"
+	"fmt"
+)
+
+// transaction.go: runs the facade's calls into its subsystems as one unit of work
+//
+// Every step that changes state registers how to undo itself. When a later
+// step fails the undo actions run newest first, so a failed operation leaves
+// the wallets and the ledger as if it never started.
+type unitOfWork struct {
+	compensations []func() error
+}
+
+// do runs action. On success compensate, if not nil, is kept for a rollback;
+// on failure everything done so far is rolled back.
+func (u *unitOfWork) do(action, compensate func() error) error {
+	err := action()
+	if err != nil {
+		return u.rollback(err)
+	}
+	if compensate != nil {
+		u.compensations = append(u.compensations, compensate)
+	}
+	return nil
+}
+
+// rollback returns cause, joined with any compensation that failed as well.
+func (u *unitOfWork) rollback(cause error) error {
+	if len(u.compensations) == 0 {
+		return cause
+	}
+	fmt.Println("Rolling back")
+	errs := []error{cause}
+	for i := len(u.compensations) - 1; i >= 0; i-- {
+		if err := u.compensations[i](); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrRollbackFailed, err))
+		}
+	}
+	u.compensations = nil
+	return errors.Join(errs...)
+}
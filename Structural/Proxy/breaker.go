@@ -0,0 +1,177 @@
+package Proxy
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateClosed:
+		return "closed"
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig tunes a CircuitBreaker. Zero HalfOpenProbes and SuccessThreshold mean 1.
+type BreakerConfig struct {
+	// FailureThreshold consecutive 5xx responses open the breaker.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before letting probes through.
+	Cooldown time.Duration
+	// HalfOpenProbes is how many requests may be in flight while half-open.
+	HalfOpenProbes int
+	// SuccessThreshold successful probes close the breaker again.
+	SuccessThreshold int
+	// OnStateChange is called after every transition, outside the breaker's lock.
+	OnStateChange func(from, to breakerState)
+	Now           clock
+}
+
+// circuitBreaker.go: Proxy that stops calling a failing subject for a while
+//
+// closed:    requests pass, consecutive failures are counted.
+// open:      requests get 503 without reaching the subject until the cooldown is over.
+// half-open: a few probes pass, one failure reopens, enough successes close.
+type CircuitBreaker struct {
+	next server
+	cfg  BreakerConfig
+
+	mu         sync.Mutex
+	state      breakerState
+	generation int
+	failures   int
+	successes  int
+	inFlight   int
+	openedAt   time.Time
+}
+
+type transition struct {
+	from, to breakerState
+}
+
+func newCircuitBreaker(next server, threshold int, cooldown time.Duration, now clock) *CircuitBreaker {
+	return newCircuitBreakerWithConfig(next, BreakerConfig{
+		FailureThreshold: threshold,
+		Cooldown:         cooldown,
+		Now:              now,
+	})
+}
+
+func newCircuitBreakerWithConfig(next server, cfg BreakerConfig) *CircuitBreaker {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = 1
+	}
+	return &CircuitBreaker{
+		next: next,
+		cfg:  cfg,
+	}
+}
+
+func (c *CircuitBreaker) handleRequest(url, method string) (int, string) {
+	generation, ok, changes := c.before()
+	c.notify(changes)
+	if !ok {
+		return 503, "Service Unavailable"
+	}
+	code, body := c.next.handleRequest(url, method)
+	c.notify(c.after(generation, code < 500))
+	return code, body
+}
+
+func (c *CircuitBreaker) currentState() breakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// before decides whether the request may reach the subject.
+func (c *CircuitBreaker) before() (int, bool, []transition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var changes []transition
+	if c.state == stateOpen && !c.cfg.Now().Before(c.openedAt.Add(c.cfg.Cooldown)) {
+		changes = append(changes, c.moveTo(stateHalfOpen))
+	}
+	switch c.state {
+	case stateOpen:
+		return c.generation, false, changes
+	case stateHalfOpen:
+		if c.inFlight >= c.cfg.HalfOpenProbes {
+			return c.generation, false, changes
+		}
+		c.inFlight++
+	}
+	return c.generation, true, changes
+}
+
+// after records the outcome. Results from before the last transition are ignored.
+func (c *CircuitBreaker) after(generation int, success bool) []transition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return nil
+	}
+	switch c.state {
+	case stateClosed:
+		if success {
+			c.failures = 0
+			return nil
+		}
+		c.failures++
+		if c.failures >= c.cfg.FailureThreshold {
+			return []transition{c.moveTo(stateOpen)}
+		}
+	case stateHalfOpen:
+		c.inFlight--
+		if !success {
+			return []transition{c.moveTo(stateOpen)}
+		}
+		c.successes++
+		if c.successes >= c.cfg.SuccessThreshold {
+			return []transition{c.moveTo(stateClosed)}
+		}
+	}
+	return nil
+}
+
+// moveTo must be called with mu held.
+func (c *CircuitBreaker) moveTo(to breakerState) transition {
+	t := transition{from: c.state, to: to}
+	c.state = to
+	c.generation++
+	c.failures = 0
+	c.successes = 0
+	c.inFlight = 0
+	if to == stateOpen {
+		c.openedAt = c.cfg.Now()
+	}
+	return t
+}
+
+func (c *CircuitBreaker) notify(changes []transition) {
+	if c.cfg.OnStateChange == nil {
+		return
+	}
+	for _, t := range changes {
+		c.cfg.OnStateChange(t.from, t.to)
+	}
+}
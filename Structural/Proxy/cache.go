@@ -0,0 +1,148 @@
+package Proxy
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cacheProxy.go: Proxy that memoizes successful responses of idempotent requests
+type CacheProxy struct {
+	application server
+	ttl         time.Duration
+	maxEntries  int
+	maxBytes    int
+	now         clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// byResource indexes the keys of entries by url without the query
+	byResource map[string]map[string]bool
+	lru        *list.List
+	size       int
+	stats      CacheStats
+}
+
+type CacheStats struct {
+	Hits          int
+	Misses        int
+	Evictions     int
+	Invalidations int
+}
+
+type cacheEntry struct {
+	key      string
+	resource string
+	code     int
+	body     string
+	expires  time.Time
+}
+
+// newCacheProxy caches for ttl. maxEntries and maxBytes (summed body length)
+// bound the cache, zero means no bound. The least recently used entry goes first.
+func newCacheProxy(application server, ttl time.Duration, maxEntries, maxBytes int, now clock) *CacheProxy {
+	if now == nil {
+		now = time.Now
+	}
+	return &CacheProxy{
+		application: application,
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+		now:         now,
+		entries:     make(map[string]*list.Element),
+		byResource:  make(map[string]map[string]bool),
+		lru:         list.New(),
+	}
+}
+
+func (c *CacheProxy) handleRequest(url, method string) (int, string) {
+	if !isCacheable(method) {
+		code, body := c.application.handleRequest(url, method)
+		c.invalidate(url)
+		return code, body
+	}
+
+	key := method + " " + url
+	if code, body, ok := c.lookup(key); ok {
+		return code, body
+	}
+	code, body := c.application.handleRequest(url, method)
+	if code >= 200 && code < 300 {
+		c.store(key, stripQuery(url), code, body)
+	}
+	return code, body
+}
+
+func isCacheable(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func (c *CacheProxy) lookup(key string) (int, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return 0, "", false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return 0, "", false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return entry.code, entry.body, true
+}
+
+func (c *CacheProxy) store(key, resource string, code int, body string) {
+	if c.maxBytes > 0 && len(body) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	entry := &cacheEntry{key: key, resource: resource, code: code, body: body, expires: c.now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.byResource[resource] == nil {
+		c.byResource[resource] = make(map[string]bool)
+	}
+	c.byResource[resource][key] = true
+	c.size += len(body)
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops everything cached for url's resource after a write to it.
+// The query is ignored, a POST /user/1?token=x changes what GET /user/1 returns.
+func (c *CacheProxy) invalidate(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.byResource[stripQuery(url)] {
+		c.remove(c.entries[key])
+		c.stats.Invalidations++
+	}
+}
+
+func (c *CacheProxy) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	delete(c.byResource[entry.resource], entry.key)
+	if len(c.byResource[entry.resource]) == 0 {
+		delete(c.byResource, entry.resource)
+	}
+	c.size -= len(entry.body)
+}
+
+func (c *CacheProxy) cacheStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
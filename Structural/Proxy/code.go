@@ -1,5 +1,11 @@
 package Proxy
 
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
 // server.go: Subject
 type server interface {
 	handleRequest(string, string) (int, string)
@@ -7,49 +13,125 @@ type server interface {
 
 // nginx.go: Proxy
 type Nginx struct {
-	application       *Application
-	maxAllowedRequest int
-	rateLimiter       map[string]int
+	application server
+	limiter     RateLimiter
+	key         keyFunc
+
+	// accessLog and metrics are optional, see withAccessLog and withMetrics.
+	accessLog *slog.Logger
+	metrics   *Metrics
 }
 
 func newNginxServer() *Nginx {
+	return newNginxProxy(&Application{})
+}
+
+// newNginxProxy puts the rate limiting proxy in front of any subject,
+// e.g. a net/http handler adapted by newHandlerServer.
+// By default every url may be hit twice a minute.
+func newNginxProxy(application server) *Nginx {
+	return newNginxProxyWithLimiter(application, newFixedWindow(2, time.Minute, nil), keyByURL)
+}
+
+func newNginxProxyWithLimiter(application server, limiter RateLimiter, key keyFunc) *Nginx {
 	return &Nginx{
-		application:       &Application{},
-		maxAllowedRequest: 2,
-		rateLimiter:       make(map[string]int),
+		application: application,
+		limiter:     limiter,
+		key:         key,
 	}
 }
 
+func (n *Nginx) withAccessLog(logger *slog.Logger) *Nginx {
+	n.accessLog = logger
+	return n
+}
+
+func (n *Nginx) withMetrics(metrics *Metrics) *Nginx {
+	n.metrics = metrics
+	return n
+}
+
 func (n *Nginx) handleRequest(url, method string) (int, string) {
-	allowed := n.checkRateLimiting(url)
-	if !allowed {
-		return 403, "Not Allowed"
+	return n.serve(request{url: url, method: method})
+}
+
+func (n *Nginx) serve(req request) (int, string) {
+	start := time.Now()
+	allowed := n.checkRateLimiting(req)
+	code, body := 403, "Not Allowed"
+	if allowed {
+		code, body = n.application.handleRequest(req.url, req.method)
 	}
-	return n.application.handleRequest(url, method)
+	n.record(req, code, !allowed, time.Since(start))
+	return code, body
 }
 
-func (n *Nginx) checkRateLimiting(url string) bool {
-	if n.rateLimiter[url] == 0 {
-		n.rateLimiter[url] = 1
+func (n *Nginx) record(req request, code int, blocked bool, elapsed time.Duration) {
+	if n.accessLog != nil {
+		level := slog.LevelInfo
+		if blocked {
+			level = slog.LevelWarn
+		}
+		n.accessLog.LogAttrs(context.Background(), level, "access",
+			slog.String("method", req.method),
+			slog.String("url", redactToken(req.url)),
+			slog.String("client", req.client),
+			slog.Int("code", code),
+			slog.Bool("blocked", blocked),
+			slog.Duration("duration", elapsed),
+		)
 	}
-	if n.rateLimiter[url] > n.maxAllowedRequest {
-		return false
+	if n.metrics != nil {
+		route := otherLabel
+		if matcher, ok := n.application.(routeMatcher); ok {
+			if pattern, ok := matcher.routeOf(req.url); ok {
+				route = pattern
+			}
+		}
+		n.metrics.observe(route, req.method, code, blocked, elapsed)
 	}
-	n.rateLimiter[url] = n.rateLimiter[url] + 1
-	return true
+}
+
+func (n *Nginx) checkRateLimiting(req request) bool {
+	return n.limiter.allow(n.key(req))
 }
 
 // application.go: Real subject
+// A zero Application serves the default routes, newApplication lets callers model their own service.
 type Application struct {
+	router *Router
 }
 
-func (a *Application) handleRequest(url, method string) (int, string) {
-	if url == "/app/status" && method == "GET" {
-		return 200, "Ok"
+func newApplication(router *Router) *Application {
+	return &Application{
+		router: router,
 	}
+}
 
-	if url == "/create/user" && method == "POST" {
+var defaultRouter = newDefaultRouter()
+
+func newDefaultRouter() *Router {
+	router := newRouter()
+	router.handle("GET", "/app/status", func(map[string]string) (int, string) {
+		return 200, "Ok"
+	})
+	router.handle("POST", "/create/user", func(map[string]string) (int, string) {
 		return 201, "User Created"
+	})
+	return router
+}
+
+func (a *Application) handleRequest(url, method string) (int, string) {
+	return a.routes().handleRequest(url, method)
+}
+
+func (a *Application) routeOf(url string) (string, bool) {
+	return a.routes().routeOf(url)
+}
+
+func (a *Application) routes() *Router {
+	if a.router == nil {
+		return defaultRouter
 	}
-	return 404, "Not Ok"
+	return a.router
 }
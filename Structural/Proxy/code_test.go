@@ -1,8 +1,18 @@
 package Proxy
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestProxy(t *testing.T) {
@@ -43,6 +53,823 @@ func TestProxy(t *testing.T) {
 	// Body: User Created
 	//
 	// Url: /app/status
-	// HttpCode: 404
-	// Body: Not Ok
+	// HttpCode: 405
+	// Body: Method Not Allowed
+}
+
+func TestProxyHTTP(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "Ok")
+	})
+	nginxServer := newNginxProxy(newHandlerServer(mux))
+	ts := httptest.NewServer(nginxServer)
+	defer ts.Close()
+
+	want := []int{200, 200, 403}
+	for i, code := range want {
+		resp, err := http.Get(ts.URL + "/app/status")
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("request %d: got code %d, want %d", i, resp.StatusCode, code)
+		}
+		fmt.Printf("\nUrl: %s\nHttpCode: %d\nBody: %s\n", "/app/status", resp.StatusCode, body)
+	}
+
+	httpCode, _ := nginxServer.handleRequest("/missing", "GET")
+	if httpCode != 404 {
+		t.Fatalf("got code %d for unknown url, want 404", httpCode)
+	}
+
+	// any subject, here a cache in front of the application, can be served with serverHandler
+	app := &countingServer{}
+	cached := httptest.NewServer(serverHandler{newCacheProxy(app, time.Minute, 0, 0, nil)})
+	defer cached.Close()
+	for _, method := range []string{"GET", "GET", "POST", "GET"} {
+		req, _ := http.NewRequest(method, cached.URL+"/user/1?id=2", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != method+" /user/1?id=2" {
+			t.Fatalf("%s: got %d %q", method, resp.StatusCode, body)
+		}
+	}
+	if app.callCount() != 3 {
+		t.Fatalf("subject called %d times, want 3", app.callCount())
+	}
+}
+
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+func allowN(limiter RateLimiter, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if limiter.allow(key) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimiters(t *testing.T) {
+	t.Run("fixed window", func(t *testing.T) {
+		c := newFakeClock()
+		limiter := newFixedWindow(2, time.Minute, c.now)
+		if got := allowN(limiter, "/a", 3); got != 2 {
+			t.Fatalf("allowed %d, want 2", got)
+		}
+		if got := allowN(limiter, "/b", 1); got != 1 {
+			t.Fatalf("other key allowed %d, want 1", got)
+		}
+		c.advance(time.Minute)
+		if got := allowN(limiter, "/a", 3); got != 2 {
+			t.Fatalf("allowed %d after window reset, want 2", got)
+		}
+	})
+
+	t.Run("sliding window log", func(t *testing.T) {
+		c := newFakeClock()
+		limiter := newSlidingWindowLog(2, time.Minute, c.now)
+		limiter.allow("/a")
+		c.advance(30 * time.Second)
+		limiter.allow("/a")
+		if limiter.allow("/a") {
+			t.Fatal("third request in window allowed")
+		}
+		c.advance(30 * time.Second)
+		if !limiter.allow("/a") {
+			t.Fatal("request after oldest entry expired was blocked")
+		}
+		if limiter.allow("/a") {
+			t.Fatal("request over limit allowed")
+		}
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		c := newFakeClock()
+		limiter := newTokenBucket(1, 3, c.now)
+		if got := allowN(limiter, "/a", 5); got != 3 {
+			t.Fatalf("allowed %d, want burst of 3", got)
+		}
+		c.advance(2 * time.Second)
+		if got := allowN(limiter, "/a", 5); got != 2 {
+			t.Fatalf("allowed %d after refill, want 2", got)
+		}
+		c.advance(time.Hour)
+		if got := allowN(limiter, "/a", 5); got != 3 {
+			t.Fatalf("allowed %d after long idle, want burst of 3", got)
+		}
+	})
+
+	t.Run("idle keys are dropped", func(t *testing.T) {
+		c := newFakeClock()
+		fixed := newFixedWindow(2, time.Minute, c.now)
+		sliding := newSlidingWindowLog(2, time.Minute, c.now)
+		bucket := newTokenBucket(1, 3, c.now)
+		limiters := []RateLimiter{fixed, sliding, bucket}
+		for i := 0; i < 5000; i++ {
+			for _, limiter := range limiters {
+				limiter.allow(fmt.Sprintf("client-%d", i))
+			}
+		}
+		c.advance(time.Minute)
+		for i := 0; i < 10000; i++ {
+			for _, limiter := range limiters {
+				limiter.allow(fmt.Sprintf("other-%d", i))
+			}
+		}
+		for i, size := range []int{fixed.windows.size(), sliding.logs.size(), bucket.buckets.size()} {
+			if size > 10000 {
+				t.Fatalf("limiter %d holds %d keys, the idle ones were kept", i, size)
+			}
+		}
+		if got := allowN(fixed, "other-1", 3); got != 1 {
+			t.Fatalf("allowed %d for a key still in its window, want 1", got)
+		}
+	})
+
+	t.Run("keyed by method", func(t *testing.T) {
+		c := newFakeClock()
+		nginxServer := newNginxProxyWithLimiter(&Application{}, newFixedWindow(1, time.Minute, c.now), keyByMethod)
+		if code, _ := nginxServer.handleRequest("/app/status", "GET"); code != 200 {
+			t.Fatalf("got code %d, want 200", code)
+		}
+		if code, _ := nginxServer.handleRequest("/create/user", "GET"); code != 403 {
+			t.Fatalf("got code %d for second GET, want 403", code)
+		}
+		if code, _ := nginxServer.handleRequest("/create/user", "POST"); code != 201 {
+			t.Fatalf("got code %d for POST, want 201", code)
+		}
+	})
+
+	t.Run("keyed by client", func(t *testing.T) {
+		c := newFakeClock()
+		nginxServer := newNginxProxyWithLimiter(&Application{}, newFixedWindow(1, time.Minute, c.now), keyByClient)
+		for i, client := range []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.1:2000"} {
+			req := httptest.NewRequest("GET", "/app/status", nil)
+			req.RemoteAddr = client
+			rec := httptest.NewRecorder()
+			nginxServer.ServeHTTP(rec, req)
+			want := 200
+			if i == 2 {
+				want = 403
+			}
+			if rec.Code != want {
+				t.Fatalf("client %s got code %d, want %d", client, rec.Code, want)
+			}
+		}
+	})
+}
+
+// TestProxyConcurrent is meant to be run with go test -race.
+func TestProxyConcurrent(t *testing.T) {
+	const (
+		workers = 64
+		calls   = 200
+		limit   = 50
+	)
+	urls := []string{"/app/status", "/create/user", "/hot/1", "/hot/2"}
+	limiters := map[string]RateLimiter{
+		"fixed window":       newFixedWindow(limit, time.Hour, nil),
+		"sliding window log": newSlidingWindowLog(limit, time.Hour, nil),
+		"token bucket":       newTokenBucket(0, limit, nil),
+	}
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			nginxServer := newNginxProxyWithLimiter(&Application{}, limiter, keyByURL)
+			var mu sync.Mutex
+			passed := make(map[string]int)
+			var wg sync.WaitGroup
+			wg.Add(workers)
+			for w := 0; w < workers; w++ {
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < calls; i++ {
+						url := urls[(w+i)%len(urls)]
+						if code, _ := nginxServer.handleRequest(url, "GET"); code != 403 {
+							mu.Lock()
+							passed[url]++
+							mu.Unlock()
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			for _, url := range urls {
+				if passed[url] != limit {
+					t.Errorf("%s passed %d requests, want exactly %d", url, passed[url], limit)
+				}
+			}
+		})
+	}
+}
+
+// countingServer is a stub subject that records how often it was reached.
+type countingServer struct {
+	mu      sync.Mutex
+	calls   int
+	respond func(url, method string) (int, string)
+}
+
+func (c *countingServer) handleRequest(url, method string) (int, string) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	if c.respond == nil {
+		return 200, method + " " + url
+	}
+	return c.respond(url, method)
+}
+
+func (c *countingServer) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestCacheProxy(t *testing.T) {
+	t.Run("ttl and stats", func(t *testing.T) {
+		c := newFakeClock()
+		app := &countingServer{}
+		cache := newCacheProxy(app, time.Minute, 0, 0, c.now)
+		cache.handleRequest("/a", "GET")
+		cache.handleRequest("/a", "GET")
+		cache.handleRequest("/a", "HEAD")
+		if app.callCount() != 2 {
+			t.Fatalf("subject called %d times, want 2", app.callCount())
+		}
+		c.advance(time.Minute)
+		cache.handleRequest("/a", "GET")
+		if app.callCount() != 3 {
+			t.Fatalf("expired entry served from cache")
+		}
+		if got, want := cache.cacheStats(), (CacheStats{Hits: 1, Misses: 3}); got != want {
+			t.Fatalf("got stats %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("write invalidates", func(t *testing.T) {
+		app := &countingServer{}
+		cache := newCacheProxy(app, time.Minute, 0, 0, nil)
+		cache.handleRequest("/user/1", "GET")
+		cache.handleRequest("/user/2", "GET")
+		cache.handleRequest("/user/1", "POST")
+		cache.handleRequest("/user/1", "POST")
+		cache.handleRequest("/user/1", "GET")
+		cache.handleRequest("/user/2", "GET")
+		if app.callCount() != 5 {
+			t.Fatalf("subject called %d times, want 5", app.callCount())
+		}
+		if got := cache.cacheStats().Invalidations; got != 1 {
+			t.Fatalf("got %d invalidations, want 1", got)
+		}
+
+		cache.handleRequest("/user/1?fields=name", "GET")
+		cache.handleRequest("/user/1?token=x", "POST")
+		cache.handleRequest("/user/1", "GET")
+		cache.handleRequest("/user/1?fields=name", "GET")
+		if app.callCount() != 9 {
+			t.Fatalf("subject called %d times, want a write with a query to invalidate every query of the resource", app.callCount())
+		}
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		app := &countingServer{}
+		cache := newCacheProxy(app, time.Minute, 2, 0, nil)
+		cache.handleRequest("/a", "GET")
+		cache.handleRequest("/b", "GET")
+		cache.handleRequest("/a", "GET")
+		cache.handleRequest("/c", "GET")
+		cache.handleRequest("/a", "GET")
+		if app.callCount() != 3 {
+			t.Fatalf("recently used entry was evicted")
+		}
+		cache.handleRequest("/b", "GET")
+		if app.callCount() != 4 {
+			t.Fatalf("least recently used entry was not evicted")
+		}
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		app := &countingServer{}
+		// every body is "GET /x", 6 bytes
+		cache := newCacheProxy(app, time.Minute, 0, 12, nil)
+		cache.handleRequest("/a", "GET")
+		cache.handleRequest("/b", "GET")
+		cache.handleRequest("/c", "GET")
+		if got := cache.cacheStats().Evictions; got != 1 {
+			t.Fatalf("got %d evictions, want 1", got)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		app := &countingServer{respond: func(url, method string) (int, string) {
+			return 404, "Not Ok"
+		}}
+		cache := newCacheProxy(app, time.Minute, 0, 0, nil)
+		cache.handleRequest("/missing", "GET")
+		cache.handleRequest("/missing", "GET")
+		if app.callCount() != 2 {
+			t.Fatalf("404 was served from cache")
+		}
+	})
+}
+
+func TestRouter(t *testing.T) {
+	router := newRouter()
+	router.handle("GET", "/user/{id}", func(params map[string]string) (int, string) {
+		return 200, "user " + params["id"]
+	})
+	router.handle("DELETE", "/user/{id}", func(params map[string]string) (int, string) {
+		return 204, ""
+	})
+	router.handle("GET", "/user/me", func(map[string]string) (int, string) {
+		return 200, "me"
+	})
+	router.handle("GET", "/static/*", func(params map[string]string) (int, string) {
+		return 200, "file " + params["*"]
+	})
+	router.handle("GET", "/user/{id}/posts/{post}", func(params map[string]string) (int, string) {
+		return 200, params["id"] + ":" + params["post"]
+	})
+	app := newApplication(router)
+
+	cases := []struct {
+		url, method string
+		code        int
+		body        string
+	}{
+		{"/user/42", "GET", 200, "user 42"},
+		{"/user/42?verbose=1", "GET", 200, "user 42"},
+		{"/user/me", "GET", 200, "me"},
+		{"/user/42", "DELETE", 204, ""},
+		{"/user/42", "POST", 405, "Method Not Allowed"},
+		{"/user/7/posts/9", "GET", 200, "7:9"},
+		{"/static/css/site.css", "GET", 200, "file css/site.css"},
+		{"/static", "GET", 200, "file "},
+		{"/user", "GET", 404, "Not Ok"},
+		{"/user/42/extra", "GET", 404, "Not Ok"},
+		{"/nowhere", "GET", 404, "Not Ok"},
+	}
+	for _, c := range cases {
+		code, body := app.handleRequest(c.url, c.method)
+		if code != c.code || body != c.body {
+			t.Errorf("%s %s: got (%d, %q), want (%d, %q)", c.method, c.url, code, body, c.code, c.body)
+		}
+	}
+
+	for url, want := range map[string]string{"/user/42?verbose=1": "/user/{id}", "/user/me": "/user/me", "/static/a/b": "/static/*"} {
+		if got, ok := app.routeOf(url); !ok || got != want {
+			t.Errorf("route of %s: got %q, want %q", url, got, want)
+		}
+	}
+	if _, ok := app.routeOf("/nowhere"); ok {
+		t.Error("unknown path matched a route")
+	}
+}
+
+func TestAuthProxy(t *testing.T) {
+	auth := newAuthProxy(&Application{}, []string{"secret"}, []string{"/app/"})
+	cases := []struct {
+		url  string
+		code int
+	}{
+		{"/app/status", 200},
+		{"/create/user", 401},
+		{"/create/user?token=wrong", 401},
+		{"/create/user?token=secret", 201},
+	}
+	for _, c := range cases {
+		method := "GET"
+		if strings.HasPrefix(c.url, "/create") {
+			method = "POST"
+		}
+		if code, _ := auth.handleRequest(c.url, method); code != c.code {
+			t.Errorf("%s: got code %d, want %d", c.url, code, c.code)
+		}
+	}
+}
+
+func TestLoggingProxy(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	logging := newLoggingProxy(&Application{}, logger)
+	logging.handleRequest("/app/status", "GET")
+	out := buf.String()
+	for _, want := range []string{"method=GET", "url=/app/status", "code=200"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log line %q does not contain %q", out, want)
+		}
+	}
+
+	buf.Reset()
+	auth := newAuthProxy(&Application{}, []string{"secret"}, nil)
+	newLoggingProxy(auth, logger).handleRequest("/app/status?token=secret&verbose=1", "GET")
+	newNginxServer().withAccessLog(logger).handleRequest("/app/status?token=secret", "GET")
+	out = buf.String()
+	if strings.Contains(out, "secret") || strings.Count(out, "token=REDACTED") != 2 {
+		t.Errorf("token not redacted from the logs:\n%s", out)
+	}
+}
+
+func TestRetryProxy(t *testing.T) {
+	failures := 2
+	app := &countingServer{respond: func(url, method string) (int, string) {
+		if failures > 0 {
+			failures--
+			return 502, "Bad Gateway"
+		}
+		return 200, "Ok"
+	}}
+	retry := newRetryProxy(app, 3, 10*time.Millisecond)
+	var waits []time.Duration
+	retry.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if code, _ := retry.handleRequest("/app/status", "GET"); code != 200 {
+		t.Fatalf("got code %d, want 200 after retries", code)
+	}
+	if len(waits) != 2 || waits[0] != 10*time.Millisecond || waits[1] != 20*time.Millisecond {
+		t.Fatalf("got backoff %v, want [10ms 20ms]", waits)
+	}
+
+	failures = 5
+	if code, _ := retry.handleRequest("/create/user", "POST"); code != 502 {
+		t.Fatalf("got code %d, want POST not to be retried", code)
+	}
+	if app.callCount() != 4 {
+		t.Fatalf("subject called %d times, want 4", app.callCount())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	c := newFakeClock()
+	healthy := false
+	app := &countingServer{respond: func(url, method string) (int, string) {
+		if healthy {
+			return 200, "Ok"
+		}
+		return 500, "Internal Server Error"
+	}}
+	breaker := newCircuitBreaker(app, 2, time.Minute, c.now)
+	breaker.handleRequest("/app/status", "GET")
+	breaker.handleRequest("/app/status", "GET")
+	if code, _ := breaker.handleRequest("/app/status", "GET"); code != 503 {
+		t.Fatalf("got code %d from open breaker, want 503", code)
+	}
+	if app.callCount() != 2 {
+		t.Fatalf("open breaker reached the subject")
+	}
+	c.advance(time.Minute)
+	healthy = true
+	if code, _ := breaker.handleRequest("/app/status", "GET"); code != 200 {
+		t.Fatalf("got code %d after cooldown, want 200", code)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var buf bytes.Buffer
+	c := newFakeClock()
+	pipeline := newPipeline(&Application{}, PipelineConfig{
+		Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+		AuthTokens:    []string{"secret"},
+		PublicPaths:   []string{"/app/"},
+		RateLimiter:   newFixedWindow(1, time.Minute, c.now),
+		RetryAttempts: 2,
+	})
+
+	if code, _ := pipeline.handleRequest("/create/user", "POST"); code != 401 {
+		t.Fatalf("got code %d without token, want 401", code)
+	}
+	if code, _ := pipeline.handleRequest("/create/user?token=secret", "POST"); code != 201 {
+		t.Fatalf("got code %d with token, want 201", code)
+	}
+	if code, _ := pipeline.handleRequest("/create/user?token=secret", "POST"); code != 403 {
+		t.Fatalf("got code %d over the rate limit, want 403", code)
+	}
+	if got := strings.Count(buf.String(), "msg=request"); got != 3 {
+		t.Fatalf("logged %d requests, want 3", got)
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	c := newFakeClock()
+	healthy := false
+	app := &countingServer{respond: func(url, method string) (int, string) {
+		if healthy {
+			return 200, "Ok"
+		}
+		return 500, "Internal Server Error"
+	}}
+	var transitions []string
+	breaker := newCircuitBreakerWithConfig(app, BreakerConfig{
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+		SuccessThreshold: 2,
+		Now:              c.now,
+		OnStateChange: func(from, to breakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		breaker.handleRequest("/app/status", "GET")
+	}
+	if breaker.currentState() != stateOpen {
+		t.Fatalf("got state %s after 3 failures, want open", breaker.currentState())
+	}
+
+	// a failed probe reopens for another cooldown
+	c.advance(time.Minute)
+	breaker.handleRequest("/app/status", "GET")
+	if breaker.currentState() != stateOpen {
+		t.Fatalf("got state %s after failed probe, want open", breaker.currentState())
+	}
+	c.advance(30 * time.Second)
+	if code, _ := breaker.handleRequest("/app/status", "GET"); code != 503 {
+		t.Fatalf("got code %d before cooldown, want 503", code)
+	}
+
+	// two good probes close it
+	c.advance(30 * time.Second)
+	healthy = true
+	breaker.handleRequest("/app/status", "GET")
+	if breaker.currentState() != stateHalfOpen {
+		t.Fatalf("got state %s after one good probe, want half-open", breaker.currentState())
+	}
+	breaker.handleRequest("/app/status", "GET")
+	if breaker.currentState() != stateClosed {
+		t.Fatalf("got state %s after two good probes, want closed", breaker.currentState())
+	}
+
+	want := []string{
+		"closed->open",
+		"open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}
+	if strings.Join(transitions, " ") != strings.Join(want, " ") {
+		t.Fatalf("got transitions %v, want %v", transitions, want)
+	}
+	if app.callCount() != 6 {
+		t.Fatalf("subject called %d times, want 6", app.callCount())
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	c := newFakeClock()
+	release := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	failing := true
+	app := &countingServer{respond: func(url, method string) (int, string) {
+		if failing {
+			return 500, "Internal Server Error"
+		}
+		entered <- struct{}{}
+		<-release
+		return 200, "Ok"
+	}}
+	breaker := newCircuitBreakerWithConfig(app, BreakerConfig{
+		FailureThreshold: 1,
+		Cooldown:         time.Minute,
+		Now:              c.now,
+	})
+	breaker.handleRequest("/app/status", "GET")
+	c.advance(time.Minute)
+	failing = false
+
+	done := make(chan int)
+	go func() {
+		code, _ := breaker.handleRequest("/app/status", "GET")
+		done <- code
+	}()
+	<-entered
+	if code, _ := breaker.handleRequest("/app/status", "GET"); code != 503 {
+		t.Fatalf("got code %d for second concurrent probe, want 503", code)
+	}
+	close(release)
+	if code := <-done; code != 200 {
+		t.Fatalf("got code %d from probe, want 200", code)
+	}
+	if breaker.currentState() != stateClosed {
+		t.Fatalf("got state %s, want closed", breaker.currentState())
+	}
+}
+
+// closingApplication records when the lazy proxy tears it down.
+type closingApplication struct {
+	Application
+	closed *atomic.Int32
+}
+
+func (c *closingApplication) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func TestLazyProxy(t *testing.T) {
+	t.Run("built once on first request", func(t *testing.T) {
+		var built atomic.Int32
+		lazy := newLazyProxy(func() server {
+			built.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return &Application{}
+		}, 0)
+		if lazy.isLoaded() {
+			t.Fatal("subject built before any request")
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if code, _ := lazy.handleRequest("/app/status", "GET"); code != 200 {
+					t.Errorf("got code %d, want 200", code)
+				}
+			}()
+		}
+		wg.Wait()
+		if built.Load() != 1 {
+			t.Fatalf("factory ran %d times, want 1", built.Load())
+		}
+	})
+
+	t.Run("torn down when idle", func(t *testing.T) {
+		var built, closed atomic.Int32
+		lazy := newLazyProxy(func() server {
+			built.Add(1)
+			return &closingApplication{closed: &closed}
+		}, 20*time.Millisecond)
+		lazy.handleRequest("/app/status", "GET")
+		deadline := time.Now().Add(time.Second)
+		for lazy.isLoaded() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if lazy.isLoaded() || closed.Load() != 1 {
+			t.Fatalf("subject not closed after idle timeout")
+		}
+		lazy.handleRequest("/app/status", "GET")
+		if built.Load() != 2 {
+			t.Fatalf("factory ran %d times, want subject rebuilt", built.Load())
+		}
+	})
+
+	t.Run("behind nginx", func(t *testing.T) {
+		nginxServer := newLazyNginxServer()
+		if code, _ := nginxServer.handleRequest("/app/status", "GET"); code != 200 {
+			t.Fatalf("got code %d, want 200", code)
+		}
+	})
+}
+
+func startRPC(t *testing.T, subject server) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go serveRPC(l, subject)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestRemoteProxy(t *testing.T) {
+	t.Run("forwards and pools connections", func(t *testing.T) {
+		l := startRPC(t, &Application{})
+		remote := newRemoteProxy("tcp", l.Addr().String(), 2, time.Second)
+		defer remote.Close()
+		for i := 0; i < 5; i++ {
+			code, body := remote.handleRequest("/app/status", "GET")
+			if code != 200 || body != "Ok" {
+				t.Fatalf("got (%d, %q), want (200, \"Ok\")", code, body)
+			}
+		}
+		if code, _ := remote.handleRequest("/create/user", "POST"); code != 201 {
+			t.Fatalf("got code %d, want 201", code)
+		}
+		if remote.dialCount() != 1 {
+			t.Fatalf("dialed %d times, want connection reused", remote.dialCount())
+		}
+	})
+
+	t.Run("zero timeout waits", func(t *testing.T) {
+		l := startRPC(t, &Application{})
+		remote := newRemoteProxy("tcp", l.Addr().String(), 1, 0)
+		defer remote.Close()
+		if code, _ := remote.handleRequest("/app/status", "GET"); code != 200 {
+			t.Fatalf("got code %d, want 200 without a deadline", code)
+		}
+	})
+
+	t.Run("concurrent callers", func(t *testing.T) {
+		l := startRPC(t, &Application{})
+		remote := newRemoteProxy("tcp", l.Addr().String(), 4, time.Second)
+		defer remote.Close()
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if code, _ := remote.handleRequest("/app/status", "GET"); code != 200 {
+					t.Errorf("got code %d, want 200", code)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		slow := &countingServer{respond: func(url, method string) (int, string) {
+			time.Sleep(200 * time.Millisecond)
+			return 200, "Ok"
+		}}
+		l := startRPC(t, slow)
+		remote := newRemoteProxy("tcp", l.Addr().String(), 1, 20*time.Millisecond)
+		defer remote.Close()
+		if code, _ := remote.handleRequest("/app/status", "GET"); code != 504 {
+			t.Fatalf("got code %d, want 504", code)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		remote := newRemoteProxy("tcp", addr, 1, 100*time.Millisecond)
+		if code, _ := remote.handleRequest("/app/status", "GET"); code != 502 {
+			t.Fatalf("got code %d, want 502", code)
+		}
+	})
+}
+
+func TestNginxObservability(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := newMetrics()
+	nginxServer := newNginxServer().
+		withAccessLog(slog.New(slog.NewJSONHandler(&buf, nil))).
+		withMetrics(metrics)
+
+	nginxServer.handleRequest("/app/status", "GET")
+	nginxServer.handleRequest("/app/status", "GET")
+	nginxServer.handleRequest("/app/status", "GET")
+	nginxServer.handleRequest("/app/status?verbose=1", "GET")
+	nginxServer.handleRequest("/create/user", "POST")
+	nginxServer.handleRequest("/probe/1", "POST")
+	nginxServer.handleRequest("/probe/2", "POST")
+	nginxServer.handleRequest("/probe/3", "BREW")
+	nginxServer.handleRequest("/probe/4", "FROB")
+
+	logs := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(logs) != 9 {
+		t.Fatalf("got %d access log lines, want 9", len(logs))
+	}
+	if !strings.Contains(logs[2], `"level":"WARN"`) || !strings.Contains(logs[2], `"blocked":true`) {
+		t.Fatalf("blocked request not logged as such: %s", logs[2])
+	}
+
+	ts := httptest.NewServer(metrics)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`nginx_requests_total{route="/app/status",method="GET",code="200",outcome="allowed"} 3`,
+		`nginx_requests_total{route="/app/status",method="GET",code="403",outcome="blocked"} 1`,
+		`nginx_requests_total{route="/create/user",method="POST",code="201",outcome="allowed"} 1`,
+		`nginx_request_duration_seconds_bucket{route="/app/status",le="+Inf"} 4`,
+		`nginx_request_duration_seconds_count{route="/create/user"} 1`,
+		`nginx_requests_total{route="other",method="POST",code="404",outcome="allowed"} 2`,
+		`nginx_requests_total{route="other",method="other",code="404",outcome="allowed"} 2`,
+		"# TYPE nginx_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("metrics missing %q\n%s", want, out)
+		}
+	}
 }
@@ -0,0 +1,95 @@
+package Proxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+)
+
+// handlerServer.go: Adapter from a real net/http handler to the subject interface
+type HandlerServer struct {
+	handler http.Handler
+}
+
+func newHandlerServer(handler http.Handler) *HandlerServer {
+	return &HandlerServer{
+		handler: handler,
+	}
+}
+
+func (h *HandlerServer) handleRequest(url, method string) (int, string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	rec := newResponseRecorder()
+	h.handler.ServeHTTP(rec, req)
+	return rec.code, rec.body.String()
+}
+
+// responseRecorder captures what the handler writes so it can be returned as (code, body).
+type responseRecorder struct {
+	header      http.Header
+	body        bytes.Buffer
+	code        int
+	wroteHeader bool
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+		code:   http.StatusOK,
+	}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.code = code
+}
+
+// serverHandler exposes any subject as a net/http handler.
+type serverHandler struct {
+	server server
+}
+
+func (s serverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code, body := s.server.handleRequest(r.URL.RequestURI(), r.Method)
+	writeResponse(w, code, body)
+}
+
+func writeResponse(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(body))
+}
+
+// ServeHTTP lets Nginx be mounted directly on an http.Server.
+// Unlike handleRequest it knows the client, so keyByClient works here.
+func (n *Nginx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code, body := n.serve(request{
+		url:    r.URL.RequestURI(),
+		method: r.Method,
+		client: clientAddr(r),
+	})
+	writeResponse(w, code, body)
+}
+
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
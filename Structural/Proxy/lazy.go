@@ -0,0 +1,95 @@
+package Proxy
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// lazyProxy.go: Virtual proxy, the real subject is only built when a request needs it
+//
+// With a non-zero idleTimeout the subject is dropped (and closed, if it is an
+// io.Closer) once no request has used it for that long, and rebuilt on the next one.
+type LazyProxy struct {
+	factory     func() server
+	idleTimeout time.Duration
+
+	mu         sync.Mutex
+	subject    server
+	active     int
+	generation int
+	timer      *time.Timer
+}
+
+func newLazyProxy(factory func() server, idleTimeout time.Duration) *LazyProxy {
+	return &LazyProxy{
+		factory:     factory,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// newLazyNginxServer is newNginxServer without building the Application up front.
+func newLazyNginxServer() *Nginx {
+	return newNginxProxy(newLazyProxy(func() server {
+		return &Application{}
+	}, 0))
+}
+
+func (l *LazyProxy) handleRequest(url, method string) (int, string) {
+	subject := l.acquire()
+	defer l.release()
+	return subject.handleRequest(url, method)
+}
+
+// acquire builds the subject if needed. Concurrent first callers wait on mu,
+// so the factory runs once.
+func (l *LazyProxy) acquire() server {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subject == nil {
+		l.subject = l.factory()
+	}
+	l.active++
+	l.generation++
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	return l.subject
+}
+
+func (l *LazyProxy) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.active > 0 || l.idleTimeout <= 0 {
+		return
+	}
+	generation := l.generation
+	l.timer = time.AfterFunc(l.idleTimeout, func() {
+		l.expire(generation)
+	})
+}
+
+// expire tears the subject down unless a request came in after the timer was set.
+func (l *LazyProxy) expire(generation int) {
+	l.mu.Lock()
+	if l.generation != generation || l.active > 0 || l.subject == nil {
+		l.mu.Unlock()
+		return
+	}
+	subject := l.subject
+	l.subject = nil
+	l.timer = nil
+	l.mu.Unlock()
+
+	if closer, ok := subject.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func (l *LazyProxy) isLoaded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.subject != nil
+}
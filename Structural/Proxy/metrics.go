@@ -0,0 +1,147 @@
+package Proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const otherLabel = "other"
+
+// knownMethods are the methods that get their own series, any other is counted as otherLabel.
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true,
+	http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// defaultBuckets are latency histogram upper bounds in seconds.
+var defaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// metrics.go: per route counters and latency histograms, exported in Prometheus text format
+//
+// The route is the router pattern that served the request, such as /user/{id}.
+// Paths no route matches, and every path of a subject without a router, share
+// otherLabel, as do unknown methods, so clients can't create series at will.
+type Metrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	requests map[requestLabels]int
+	latency  map[string]*histogram
+}
+
+type requestLabels struct {
+	route   string
+	method  string
+	code    int
+	outcome string
+}
+
+type histogram struct {
+	counts []int
+	sum    float64
+	count  int
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		buckets:  defaultBuckets,
+		requests: make(map[requestLabels]int),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) observe(route, method string, code int, blocked bool, elapsed time.Duration) {
+	if !knownMethods[method] {
+		method = otherLabel
+	}
+	outcome := "allowed"
+	if blocked {
+		outcome = "blocked"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{route: route, method: method, code: code, outcome: outcome}]++
+	h, ok := m.latency[route]
+	if !ok {
+		h = &histogram{counts: make([]int, len(m.buckets))}
+		m.latency[route] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, le := range m.buckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) writePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	b.WriteString("# HELP nginx_requests_total Requests seen by the proxy.\n")
+	b.WriteString("# TYPE nginx_requests_total counter\n")
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, c := labels[i], labels[j]
+		if a.route != c.route {
+			return a.route < c.route
+		}
+		if a.method != c.method {
+			return a.method < c.method
+		}
+		if a.code != c.code {
+			return a.code < c.code
+		}
+		return a.outcome < c.outcome
+	})
+	for _, l := range labels {
+		fmt.Fprintf(&b, "nginx_requests_total{route=%s,method=%s,code=\"%d\",outcome=%s} %d\n",
+			quoteLabel(l.route), quoteLabel(l.method), l.code, quoteLabel(l.outcome), m.requests[l])
+	}
+
+	b.WriteString("# HELP nginx_request_duration_seconds Time spent answering a request.\n")
+	b.WriteString("# TYPE nginx_request_duration_seconds histogram\n")
+	routes := make([]string, 0, len(m.latency))
+	for route := range m.latency {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := m.latency[route]
+		for i, le := range m.buckets {
+			fmt.Fprintf(&b, "nginx_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n",
+				quoteLabel(route), strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(&b, "nginx_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", quoteLabel(route), h.count)
+		fmt.Fprintf(&b, "nginx_request_duration_seconds_sum{route=%s} %s\n", quoteLabel(route), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "nginx_request_duration_seconds_count{route=%s} %d\n", quoteLabel(route), h.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP is the scrape endpoint.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writePrometheus(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
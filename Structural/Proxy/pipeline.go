@@ -0,0 +1,213 @@
+package Proxy
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// middleware wraps a subject in one more proxy.
+type middleware func(server) server
+
+// chain stacks the proxies in front of application, the first one listed sees the request first.
+func chain(application server, middlewares ...middleware) server {
+	s := application
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		s = middlewares[i](s)
+	}
+	return s
+}
+
+// PipelineConfig describes which proxies to put in front of the subject.
+// A stage whose settings are left zero is not added.
+type PipelineConfig struct {
+	Logger *slog.Logger
+
+	AuthTokens  []string
+	PublicPaths []string
+
+	RateLimiter  RateLimiter
+	RateLimitKey keyFunc
+
+	RetryAttempts int
+	RetryBackoff  time.Duration
+
+	Breaker BreakerConfig
+}
+
+// newPipeline builds logging -> auth -> rate limit -> retry -> circuit breaker -> application.
+func newPipeline(application server, cfg PipelineConfig) server {
+	var middlewares []middleware
+	if cfg.Logger != nil {
+		middlewares = append(middlewares, withLogging(cfg.Logger))
+	}
+	if len(cfg.AuthTokens) > 0 {
+		middlewares = append(middlewares, withAuth(cfg.AuthTokens, cfg.PublicPaths))
+	}
+	if cfg.RateLimiter != nil {
+		key := cfg.RateLimitKey
+		if key == nil {
+			key = keyByURL
+		}
+		middlewares = append(middlewares, withRateLimit(cfg.RateLimiter, key))
+	}
+	if cfg.RetryAttempts > 1 {
+		middlewares = append(middlewares, withRetry(cfg.RetryAttempts, cfg.RetryBackoff))
+	}
+	if cfg.Breaker.FailureThreshold > 0 {
+		middlewares = append(middlewares, withCircuitBreaker(cfg.Breaker))
+	}
+	return chain(application, middlewares...)
+}
+
+func withLogging(logger *slog.Logger) middleware {
+	return func(next server) server {
+		return newLoggingProxy(next, logger)
+	}
+}
+
+func withAuth(tokens, publicPaths []string) middleware {
+	return func(next server) server {
+		return newAuthProxy(next, tokens, publicPaths)
+	}
+}
+
+func withRateLimit(limiter RateLimiter, key keyFunc) middleware {
+	return func(next server) server {
+		return newNginxProxyWithLimiter(next, limiter, key)
+	}
+}
+
+func withRetry(attempts int, backoff time.Duration) middleware {
+	return func(next server) server {
+		return newRetryProxy(next, attempts, backoff)
+	}
+}
+
+func withCircuitBreaker(cfg BreakerConfig) middleware {
+	return func(next server) server {
+		return newCircuitBreakerWithConfig(next, cfg)
+	}
+}
+
+// loggingProxy.go: Proxy that keeps a history of requests
+type LoggingProxy struct {
+	next   server
+	logger *slog.Logger
+}
+
+func newLoggingProxy(next server, logger *slog.Logger) *LoggingProxy {
+	return &LoggingProxy{
+		next:   next,
+		logger: logger,
+	}
+}
+
+func (l *LoggingProxy) handleRequest(url, method string) (int, string) {
+	start := time.Now()
+	code, body := l.next.handleRequest(url, method)
+	l.logger.Info("request",
+		"method", method,
+		"url", redactToken(url),
+		"code", code,
+		"duration", time.Since(start),
+	)
+	return code, body
+}
+
+// authProxy.go: Protection proxy, requests must carry ?token=... unless the path is public
+type AuthProxy struct {
+	next        server
+	tokens      map[string]bool
+	publicPaths []string
+}
+
+func newAuthProxy(next server, tokens, publicPaths []string) *AuthProxy {
+	a := &AuthProxy{
+		next:        next,
+		tokens:      make(map[string]bool),
+		publicPaths: publicPaths,
+	}
+	for _, token := range tokens {
+		a.tokens[token] = true
+	}
+	return a
+}
+
+func (a *AuthProxy) handleRequest(rawURL, method string) (int, string) {
+	if !a.isPublic(stripQuery(rawURL)) && !a.tokens[requestToken(rawURL)] {
+		return 401, "Unauthorized"
+	}
+	return a.next.handleRequest(rawURL, method)
+}
+
+func (a *AuthProxy) isPublic(path string) bool {
+	for _, prefix := range a.publicPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func requestToken(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("token")
+}
+
+// redactToken hides the credential AuthProxy reads, so a url can be logged.
+func redactToken(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return stripQuery(rawURL)
+	}
+	query := u.Query()
+	if !query.Has("token") {
+		return rawURL
+	}
+	query.Set("token", "REDACTED")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// retryProxy.go: Proxy that repeats idempotent requests the subject failed with 5xx
+type RetryProxy struct {
+	next     server
+	attempts int
+	backoff  time.Duration
+	sleep    func(time.Duration)
+}
+
+// newRetryProxy tries at most attempts times, waiting backoff before the
+// first retry and doubling the wait after each one.
+func newRetryProxy(next server, attempts int, backoff time.Duration) *RetryProxy {
+	return &RetryProxy{
+		next:     next,
+		attempts: attempts,
+		backoff:  backoff,
+		sleep:    time.Sleep,
+	}
+}
+
+func (r *RetryProxy) handleRequest(url, method string) (int, string) {
+	code, body := r.next.handleRequest(url, method)
+	wait := r.backoff
+	for attempt := 1; attempt < r.attempts && code >= 500 && isIdempotent(method); attempt++ {
+		r.sleep(wait)
+		wait *= 2
+		code, body = r.next.handleRequest(url, method)
+	}
+	return code, body
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
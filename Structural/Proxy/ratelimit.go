@@ -0,0 +1,169 @@
+package Proxy
+
+import "time"
+
+// clock is injected into time based proxies so tests can move time by hand.
+type clock func() time.Time
+
+// request is what the proxy knows about an incoming call.
+// client is only filled in when the call comes in over HTTP.
+type request struct {
+	url    string
+	method string
+	client string
+}
+
+// keyFunc picks the bucket a request is counted against.
+type keyFunc func(request) string
+
+func keyByURL(r request) string {
+	return r.url
+}
+
+func keyByMethod(r request) string {
+	return r.method
+}
+
+func keyByClient(r request) string {
+	return r.client
+}
+
+// RateLimiter decides whether one more request for key may pass.
+type RateLimiter interface {
+	allow(key string) bool
+}
+
+// tokenBucket.go: refills rate tokens per second up to burst, each request takes one
+type TokenBucket struct {
+	rate    float64
+	burst   int
+	now     clock
+	buckets *shardedMap[bucket]
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now clock) *TokenBucket {
+	if now == nil {
+		now = time.Now
+	}
+	t := &TokenBucket{
+		rate:  rate,
+		burst: burst,
+		now:   now,
+	}
+	// a bucket that has refilled is the same as a new one
+	t.buckets = newShardedMap(func(b *bucket) bool {
+		return b.tokens+t.now().Sub(b.last).Seconds()*t.rate >= float64(t.burst)
+	})
+	return t
+}
+
+func (t *TokenBucket) allow(key string) bool {
+	now := t.now()
+	allowed := false
+	t.buckets.with(key, func(b *bucket) {
+		if b.last.IsZero() {
+			b.tokens = float64(t.burst)
+			b.last = now
+		}
+		if now.After(b.last) {
+			b.tokens += now.Sub(b.last).Seconds() * t.rate
+			b.last = now
+		}
+		if b.tokens > float64(t.burst) {
+			b.tokens = float64(t.burst)
+		}
+		if b.tokens >= 1 {
+			b.tokens--
+			allowed = true
+		}
+	})
+	return allowed
+}
+
+// fixedWindow.go: at most limit requests per window, a window starts with the first request in it
+type FixedWindow struct {
+	limit   int
+	window  time.Duration
+	now     clock
+	windows *shardedMap[windowCount]
+}
+
+type windowCount struct {
+	start time.Time
+	count int
+}
+
+func newFixedWindow(limit int, window time.Duration, now clock) *FixedWindow {
+	if now == nil {
+		now = time.Now
+	}
+	f := &FixedWindow{
+		limit:  limit,
+		window: window,
+		now:    now,
+	}
+	f.windows = newShardedMap(func(w *windowCount) bool {
+		return !f.now().Before(w.start.Add(f.window))
+	})
+	return f
+}
+
+func (f *FixedWindow) allow(key string) bool {
+	now := f.now()
+	allowed := false
+	f.windows.with(key, func(w *windowCount) {
+		if w.start.IsZero() || !now.Before(w.start.Add(f.window)) {
+			*w = windowCount{start: now}
+		}
+		if w.count < f.limit {
+			w.count++
+			allowed = true
+		}
+	})
+	return allowed
+}
+
+// slidingWindowLog.go: at most limit requests in any window ending now
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+	now    clock
+	logs   *shardedMap[[]time.Time]
+}
+
+func newSlidingWindowLog(limit int, window time.Duration, now clock) *SlidingWindowLog {
+	if now == nil {
+		now = time.Now
+	}
+	s := &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		now:    now,
+	}
+	s.logs = newShardedMap(func(log *[]time.Time) bool {
+		return len(*log) == 0 || !(*log)[len(*log)-1].After(s.now().Add(-s.window))
+	})
+	return s
+}
+
+func (s *SlidingWindowLog) allow(key string) bool {
+	now := s.now()
+	allowed := false
+	s.logs.with(key, func(log *[]time.Time) {
+		i := 0
+		for i < len(*log) && !(*log)[i].After(now.Add(-s.window)) {
+			i++
+		}
+		*log = (*log)[i:]
+		if len(*log) < s.limit {
+			*log = append(*log, now)
+			allowed = true
+		}
+	})
+	return allowed
+}
@@ -0,0 +1,147 @@
+package Proxy
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// RPCRequest and RPCResponse travel over net/rpc, so their fields are exported for gob.
+type RPCRequest struct {
+	URL    string
+	Method string
+}
+
+type RPCResponse struct {
+	Code int
+	Body string
+}
+
+// ApplicationService is the net/rpc receiver that runs next to the real subject.
+type ApplicationService struct {
+	subject server
+}
+
+func (a *ApplicationService) HandleRequest(req RPCRequest, resp *RPCResponse) error {
+	resp.Code, resp.Body = a.subject.handleRequest(req.URL, req.Method)
+	return nil
+}
+
+// serveRPC exposes subject on l. It returns the accept error once l is closed.
+func serveRPC(l net.Listener, subject server) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Application", &ApplicationService{subject: subject}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// remoteProxy.go: Remote proxy, forwards requests to a subject in another process
+//
+// Connections are pooled up to poolSize idle clients. Failures come back as status codes:
+// 502 when the remote can't be reached, 504 when it doesn't answer within timeout,
+// 500 when the remote method itself returned an error. A zero timeout waits
+// as long as dialing and the call take.
+type RemoteProxy struct {
+	network string
+	addr    string
+	timeout time.Duration
+	pool    chan *rpc.Client
+
+	mu     sync.Mutex
+	dialed int
+}
+
+func newRemoteProxy(network, addr string, poolSize int, timeout time.Duration) *RemoteProxy {
+	return &RemoteProxy{
+		network: network,
+		addr:    addr,
+		timeout: timeout,
+		pool:    make(chan *rpc.Client, poolSize),
+	}
+}
+
+func (r *RemoteProxy) handleRequest(url, method string) (int, string) {
+	client, err := r.get()
+	if err != nil {
+		return 502, "Bad Gateway"
+	}
+	var resp RPCResponse
+	call := client.Go("Application.HandleRequest", RPCRequest{URL: url, Method: method}, &resp, make(chan *rpc.Call, 1))
+	var expired <-chan time.Time
+	if r.timeout > 0 {
+		timer := time.NewTimer(r.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-call.Done:
+	case <-expired:
+		// the reply may still arrive later, so this connection can't be reused
+		client.Close()
+		return 504, "Gateway Timeout"
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(call.Error, &serverErr) {
+		r.put(client)
+		return 500, serverErr.Error()
+	}
+	if call.Error != nil {
+		client.Close()
+		return 502, "Bad Gateway"
+	}
+	r.put(client)
+	return resp.Code, resp.Body
+}
+
+func (r *RemoteProxy) get() (*rpc.Client, error) {
+	select {
+	case client := <-r.pool:
+		return client, nil
+	default:
+	}
+	conn, err := net.DialTimeout(r.network, r.addr, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.dialed++
+	r.mu.Unlock()
+	return rpc.NewClient(conn), nil
+}
+
+func (r *RemoteProxy) put(client *rpc.Client) {
+	select {
+	case r.pool <- client:
+	default:
+		client.Close()
+	}
+}
+
+// Close drops every pooled connection.
+func (r *RemoteProxy) Close() error {
+	for {
+		select {
+		case client := <-r.pool:
+			client.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *RemoteProxy) dialCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dialed
+}
@@ -0,0 +1,156 @@
+package Proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeHandler answers a matched request. params holds the {name} segments of
+// the pattern, and "*" holds whatever a trailing wildcard matched.
+type routeHandler func(params map[string]string) (int, string)
+
+// router.go: method and path based dispatch for the real subject
+//
+// Patterns are split on "/". A segment is a literal, a {name} parameter that
+// matches exactly one segment, or a trailing * that matches the rest of the path.
+// When several patterns match, literals win over parameters and parameters win over *.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]routeHandler
+}
+
+func newRouter() *Router {
+	return &Router{}
+}
+
+func (r *Router) handle(method, pattern string, h routeHandler) {
+	for _, rt := range r.routes {
+		if rt.pattern == pattern {
+			rt.handlers[method] = h
+			return
+		}
+	}
+	r.routes = append(r.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]routeHandler{method: h},
+	})
+}
+
+func (r *Router) handleRequest(url, method string) (int, string) {
+	best, params, pathMatched := r.find(url, method)
+	if best != nil {
+		return best.handlers[method](params)
+	}
+	if pathMatched {
+		return http.StatusMethodNotAllowed, "Method Not Allowed"
+	}
+	return http.StatusNotFound, "Not Ok"
+}
+
+// routeOf returns the pattern url is served by, whatever the method.
+func (r *Router) routeOf(url string) (string, bool) {
+	best, _, _ := r.find(url, "")
+	if best == nil {
+		return "", false
+	}
+	return best.pattern, true
+}
+
+// find returns the most specific route matching url that handles method,
+// an empty method accepts any route. pathMatched tells whether any route
+// matched the path at all.
+func (r *Router) find(url, method string) (best *route, params map[string]string, pathMatched bool) {
+	segments := splitPath(stripQuery(url))
+	for _, rt := range r.routes {
+		p, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if _, ok := rt.handlers[method]; !ok && method != "" {
+			continue
+		}
+		if best == nil || rt.moreSpecific(best) {
+			best, params = rt, p
+		}
+	}
+	return best, params, pathMatched
+}
+
+// routeMatcher is a subject that can tell which route serves a url, metrics
+// label requests with it.
+type routeMatcher interface {
+	routeOf(url string) (string, bool)
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, seg := range rt.segments {
+		if seg == "*" {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if name, ok := paramName(seg); ok {
+			params[name] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		a, b := segmentRank(rt.segments[i]), segmentRank(other.segments[i])
+		if a != b {
+			return a < b
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func segmentRank(seg string) int {
+	if seg == "*" {
+		return 2
+	}
+	if _, ok := paramName(seg); ok {
+		return 1
+	}
+	return 0
+}
+
+func paramName(seg string) (string, bool) {
+	if len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
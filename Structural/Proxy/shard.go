@@ -0,0 +1,82 @@
+package Proxy
+
+import "sync"
+
+const shardCount = 32
+
+// sweepEvery is the least number of calls to with between two sweeps of a shard.
+const sweepEvery = 256
+
+// shardedMap spreads keys over independently locked shards, so requests for
+// one hot url only contend with the keys that happen to share its shard.
+//
+// Keys are dropped again once idle reports their value is as good as new, so
+// clients that stop sending don't hold memory forever.
+type shardedMap[V any] struct {
+	shards [shardCount]shard[V]
+	idle   func(v *V) bool
+}
+
+type shard[V any] struct {
+	mu    sync.Mutex
+	m     map[string]*V
+	calls int
+}
+
+func newShardedMap[V any](idle func(v *V) bool) *shardedMap[V] {
+	s := &shardedMap[V]{idle: idle}
+	for i := range s.shards {
+		s.shards[i].m = make(map[string]*V)
+	}
+	return s
+}
+
+// with runs fn on the value stored for key while holding its shard lock.
+// A zero value is stored first if the key is new.
+func (s *shardedMap[V]) with(key string, fn func(v *V)) {
+	sh := &s.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[key]
+	if !ok {
+		v = new(V)
+		sh.m[key] = v
+	}
+	fn(v)
+	sh.calls++
+	if s.idle != nil && sh.calls >= max(sweepEvery, len(sh.m)) {
+		sh.calls = 0
+		s.sweep(sh)
+	}
+}
+
+// sweep must be called with sh.mu held. Sweeping only after as many calls
+// as the shard has keys keeps its cost per call constant.
+func (s *shardedMap[V]) sweep(sh *shard[V]) {
+	for key, v := range sh.m {
+		if s.idle(v) {
+			delete(sh.m, key)
+		}
+	}
+}
+
+func (s *shardedMap[V]) size() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.m)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// shardIndex is FNV-1a, inlined to avoid allocating a hash.Hash per request.
+func shardIndex(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h % shardCount
+}